@@ -1,21 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	"database/sql"
-
-	_ "github.com/go-sql-driver/mysql"
-
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 	"github.com/miumoin/agencybot/packages/controllers"
+	"github.com/miumoin/agencybot/packages/database"
+	"github.com/miumoin/agencybot/packages/migrations"
+	"github.com/miumoin/agencybot/packages/services"
 )
 
 func main() {
@@ -25,33 +29,128 @@ func main() {
 		log.Println("Error loading .env file (using system env vars instead)")
 	}
 
-	// Connect to MySQL database
-	dsn := os.Getenv("DB_URL")
-	var db *sql.DB
-	var dberr error
-	db, dberr = sql.Open("mysql", dsn)
+	// Register block types declared outside the code
+	if blockTypesFile := os.Getenv("BLOCK_TYPES_FILE"); blockTypesFile != "" {
+		if err := services.LoadBlockTypes(blockTypesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Master keys for secret metas
+	if err := services.LoadSecretKeys(os.Getenv("SECRET_KEYS"), os.Getenv("SECRET_KEY_ID")); err != nil {
+		log.Fatal(err)
+	}
+	if !services.SecretsConfigured() {
+		log.Println("SECRET_KEYS is not set: writes of secret metas will be refused")
+	}
+
+	// Connect to MySQL, PostgreSQL or SQLite, picked from the DB_URL scheme
+	db, dberr := database.Open(os.Getenv("DB_URL"))
 	if dberr != nil {
 		fmt.Println("Error connecting to database:", dberr)
 		return
 	}
 	defer db.Close()
 
+	// Schema migrations: ./app migrate [up|down|status|to <version>]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.Run(db, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Development databases are kept at the latest schema
+	if os.Getenv("APP_ENV") == "development" {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if applied > 0 {
+			log.Printf("applied %d migrations", applied)
+		}
+	}
+
+	// Bound every statement served from here on; migrations above may run longer
+	db.StatementTimeout = durationEnv("DB_STATEMENT_TIMEOUT", 10*time.Second)
+
+	// Re-encrypt secrets with the active key: ./app rotate-secrets
+	if len(os.Args) > 1 && os.Args[1] == "rotate-secrets" {
+		rotated, err := services.RotateSecrets(context.Background(), db)
+		if err != nil {
+			log.Fatalf("secret rotation stopped after %d secrets: %v", rotated, err)
+		}
+		log.Printf("re-encrypted %d secrets", rotated)
+		return
+	}
+
+	// Let a user change the system settings: ./app grant-admin <domain> <email>
+	if len(os.Args) > 1 && os.Args[1] == "grant-admin" {
+		if len(os.Args) != 4 {
+			log.Fatal("usage: grant-admin <domain> <email>")
+		}
+		if err := services.GrantSystemAdmin(context.Background(), services.NewSQLStores(db), os.Args[2], os.Args[3]); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is an admin of %s", os.Args[3], os.Args[2])
+		return
+	}
+
+	// Block and meta change events; subscribers register on the bus
+	events := services.NewEventBus()
+	services.SetEventBus(events)
+	defer events.Wait()
+
+	// Full-text search backend: database (default) or memory
+	switch backend := os.Getenv("SEARCH_BACKEND"); backend {
+	case "", "database":
+	case "memory":
+		index, err := services.LoadMemoryIndex(context.Background(), db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		index.Subscribe(events, db)
+		services.SetSearchBackend(index)
+	default:
+		log.Fatalf("unknown SEARCH_BACKEND %q", backend)
+	}
+
+	// Query embeddings for hybrid retrieval
+	if embeddingURL := os.Getenv("EMBEDDING_API_URL"); embeddingURL != "" {
+		services.SetEmbedder(services.NewHTTPEmbedder(embeddingURL, os.Getenv("EMBEDDING_API_KEY"), os.Getenv("EMBEDDING_MODEL")))
+	}
+
+	// Permanently remove blocks that have been in the trash too long
+	retentionDays, rErr := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if rErr != nil || retentionDays <= 0 {
+		retentionDays = 30
+	}
+	stopPurger := services.StartTrashPurger(db, time.Duration(retentionDays)*24*time.Hour, time.Hour)
+	defer stopPurger()
+
 	// Setup router
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
+	// Deadline for each request, passed down to queries and outbound calls
+	router.Use(controllers.RequestTimeout(durationEnv("REQUEST_TIMEOUT", 30*time.Second)))
+
 	// Enable CORS for all origins
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	// Initialize services
-	apiController := controllers.NewApiController(db, router)
+	apiController := controllers.NewApiController(db, services.NewSQLStores(db), router)
 	apiController.RegisterApiRoutes()
 	apiController.RegisterHomeRoutes()
 
@@ -62,9 +161,41 @@ func main() {
 	}
 
 	// start the server (this is required!)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
 	log.Printf("Server running on port %s", port)
-	routerErr := router.Run(":" + port) // or any port you prefer
-	if routerErr != nil {
-		log.Fatal(routerErr)
+
+	// On SIGINT or SIGTERM finish the requests in flight, then let the
+	// deferred calls stop the purger, drain event handlers and close the
+	// database
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	select {
+	case err := <-serverErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Server failed:", err)
+		}
+		return
+	case <-stop.Done():
+	}
+
+	log.Println("Shutting down")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancelShutdown()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Shutdown did not finish:", err)
+	}
+}
+
+// durationEnv reads a duration such as 5s or 1m from the environment, falling
+// back to def when it is unset or invalid
+func durationEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
 	}
+	return d
 }
@@ -1,31 +1,41 @@
 package controllers
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/database"
 	"github.com/miumoin/agencybot/packages/services"
 )
 
 type ApiController struct {
-	db     *sql.DB
+	db     *database.DB
+	stores services.Stores
 	router *gin.Engine
 }
 
 func NewApiController(
-	db *sql.DB,
+	db *database.DB,
+	stores services.Stores,
 	router *gin.Engine,
 ) *ApiController {
 	return &ApiController{
 		db:     db,
+		stores: stores,
 		router: router,
 	}
 }
 
+// databaseManager builds the manager for a request from its domain and access
+// key headers
+func (ac *ApiController) databaseManager(ctx context.Context, domain, accessKey string) (*services.DatabaseManager, error) {
+	return services.NewDatabaseManagerWithStores(ctx, ac.db, ac.stores, domain, accessKey)
+}
+
 func (ac *ApiController) RegisterApiRoutes() {
 	apiGroup := ac.router.Group("/api")
 	{
@@ -38,6 +48,44 @@ func (ac *ApiController) RegisterApiRoutes() {
 		apiGroup.GET("/workspace/:slug", ac.GetWorkspace)
 		apiGroup.POST("/workspace/:slug/update", ac.UpdateWorkspace)
 		apiGroup.GET("/workspace/:slug/threads/:page", ac.GetThreads)
+		apiGroup.GET("/workspace/:slug/settings", ac.GetWorkspaceSettings)
+		apiGroup.PATCH("/workspace/:slug/settings", ac.UpdateWorkspaceSettings)
+		apiGroup.POST("/workspace/:slug/logo", ac.UploadWorkspaceLogo)
+		apiGroup.POST("/workspace/:slug/retrieve", ac.Retrieve)
+		apiGroup.POST("/workspace/:slug/retrieve/debug", ac.RetrieveDebug)
+		apiGroup.GET("/workspace/:slug/retrieval-weights", ac.GetRetrievalWeights)
+		apiGroup.PUT("/workspace/:slug/retrieval-weights", ac.UpdateRetrievalWeights)
+		apiGroup.GET("/settings", ac.GetSystemSettings)
+		apiGroup.PATCH("/settings", ac.UpdateSystemSettings)
+		apiGroup.GET("/block-types", ac.ListBlockTypes)
+		apiGroup.GET("/templates", ac.GetTemplates)
+		apiGroup.GET("/search", ac.Search)
+		apiGroup.GET("/blocks/:type", ac.ListBlocks)
+		apiGroup.POST("/blocks/:type", ac.CreateBlock)
+		apiGroup.GET("/blocks/:type/:slug", ac.GetTypedBlock)
+		apiGroup.PUT("/blocks/:type/:slug", ac.UpdateBlock)
+		apiGroup.PATCH("/blocks/:type/:slug", ac.PatchBlock)
+		apiGroup.DELETE("/blocks/:type/:slug", ac.DeleteBlock)
+		apiGroup.GET("/blocks/:type/:slug/tree", ac.GetBlockTree)
+		apiGroup.GET("/blocks/:type/:slug/ancestors", ac.GetBlockAncestors)
+		apiGroup.GET("/blocks/:type/:slug/descendants/count", ac.CountBlockDescendants)
+		apiGroup.POST("/blocks/:type/:slug/move", ac.MoveBlock)
+		apiGroup.POST("/blocks/:type/:slug/position", ac.RepositionBlock)
+		apiGroup.POST("/blocks/:type/:slug/clone", ac.CloneBlock)
+		apiGroup.PATCH("/blocks/:type/:slug/metas", ac.UpdateBlockMetas)
+		apiGroup.GET("/blocks/:type/:slug/metas/:key", ac.GetBlockMetaPath)
+		apiGroup.PATCH("/blocks/:type/:slug/metas/:key", ac.PatchBlockMetaPath)
+		apiGroup.POST("/blocks/:type/:slug/metas/:key/increment", ac.IncrementBlockMeta)
+		apiGroup.GET("/blocks/:type/:slug/links", ac.GetBlockLinks)
+		apiGroup.POST("/blocks/:type/:slug/links", ac.AddBlockLink)
+		apiGroup.DELETE("/blocks/:type/:slug/links/:relation/:target", ac.RemoveBlockLink)
+		apiGroup.GET("/blocks/:type/:slug/trash", ac.GetBlockTrash)
+		apiGroup.GET("/blocks/:type/:slug/revisions", ac.GetBlockRevisions)
+		apiGroup.GET("/blocks/:type/:slug/revisions/:revision", ac.GetBlockRevision)
+		apiGroup.POST("/blocks/:type/:slug/revisions/:revision/restore", ac.RestoreBlockRevision)
+		apiGroup.GET("/trash", ac.GetTrash)
+		apiGroup.POST("/trash/:slug/restore", ac.RestoreTrashedBlock)
+		apiGroup.DELETE("/trash/:slug", ac.PurgeTrashedBlock)
 		apiGroup.GET("/welcome", ac.ApiWelcome)
 	}
 }
@@ -51,10 +99,11 @@ func (ac *ApiController) ApiWelcome(c *gin.Context) {
 }
 
 func (ac *ApiController) Login(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 
-	databaseManager, err := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, err := ac.databaseManager(ctx, domain, accessKey)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -64,8 +113,8 @@ func (ac *ApiController) Login(c *gin.Context) {
 	}
 
 	// Note: DatabaseManager and utilities.makeLogin implementation needed
-	utils := services.NewUtilities(ac.db)
-	userID, userEmail, newAccessKey, err := utils.MakeLogin(*databaseManager, c)
+	utils := services.NewUtilities(ac.db, ac.stores)
+	userID, userEmail, newAccessKey, err := utils.MakeLogin(ctx, *databaseManager, c)
 	if err == nil && userEmail != "" {
 		fmt.Println("User logged in: ", userEmail)
 	}
@@ -77,10 +126,11 @@ func (ac *ApiController) Login(c *gin.Context) {
 }
 
 func (ac *ApiController) Verify(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 
-	databaseManager, err := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, err := ac.databaseManager(ctx, domain, accessKey)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -98,22 +148,16 @@ func (ac *ApiController) Verify(c *gin.Context) {
 		return
 	}
 
-	var userID int64 = 0
 	var userEmail string = ""
 
-	row := ac.db.QueryRow(`
-		SELECT m.parent_id
-		FROM metas m
-		WHERE m.meta_value LIKE ? AND m.meta_value LIKE ? 
-	`, "%code%", "%"+content.Code+"%")
-
-	if err := row.Scan(&userID); err == nil && userID > 0 {
+	utils := services.NewUtilities(ac.db, ac.stores)
+	userID, err := utils.VerifyLoginCode(ctx, content.Code)
+	if err == nil && userID > 0 {
 		// Note: getAccessKey implementation needed
-		emailAndKey, err := databaseManager.GetAccessKey(userID)
-		if err != nil {
-			return
+		emailAndKey, err := databaseManager.GetAccessKey(ctx, userID)
+		if err == nil && emailAndKey != nil {
+			userEmail, accessKey = emailAndKey[0], emailAndKey[1]
 		}
-		userEmail, accessKey = emailAndKey[0], emailAndKey[1]
 	}
 
 	if userEmail == "" {
@@ -129,6 +173,7 @@ func (ac *ApiController) Verify(c *gin.Context) {
 }
 
 func (ac *ApiController) GetWorkspaces(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 	page, sErr := strconv.Atoi(c.Param("page_no"))
@@ -136,7 +181,7 @@ func (ac *ApiController) GetWorkspaces(c *gin.Context) {
 		page = 1
 	}
 
-	databaseManager, dErr := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
 	if dErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -147,10 +192,16 @@ func (ac *ApiController) GetWorkspaces(c *gin.Context) {
 
 	userID := databaseManager.GetCurrentUser()
 
-	utils := services.NewUtilities(ac.db)
+	utils := services.NewUtilities(ac.db, ac.stores)
+
+	listOptions, lErr := services.ParseListOptions(c.Request.URL.Query())
+	if lErr != nil {
+		failWithError(c, lErr)
+		return
+	}
 
 	//workspaces, limit, err := utils.GetWorkspaces(*databaseManager, 20)
-	workspaces, err := databaseManager.GetBlocks(userID, "workspace", page, 20, 0)
+	workspaces, err := databaseManager.GetBlocks(ctx, userID, "workspace", page, 0, listOptions)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -159,27 +210,20 @@ func (ac *ApiController) GetWorkspaces(c *gin.Context) {
 		return
 	}
 
-	subscription, sErr := utils.GetSubscriptionInfo(ac.db, userID)
+	subscription, sErr := utils.GetSubscriptionInfo(ctx, userID)
 	if sErr != nil {
 		//do nothing
 	}
 
-	var workspacesOut []map[string]interface{}
-	if workspaces == nil {
-		workspacesOut = []map[string]interface{}{}
-	} else {
-		workspacesOut = workspaces
-	}
-
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, withPagination(gin.H{
 		"status":       "success",
-		"workspaces":   workspacesOut,
-		"limit":        20,
+		"workspaces":   workspaces.Blocks,
 		"subscription": subscription,
-	})
+	}, workspaces, listOptions))
 }
 
 func (ac *ApiController) AddNewWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 
@@ -192,7 +236,7 @@ func (ac *ApiController) AddNewWorkspace(c *gin.Context) {
 		return
 	}
 
-	databaseManager, dErr := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
 	if dErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -210,7 +254,16 @@ func (ac *ApiController) AddNewWorkspace(c *gin.Context) {
 		"parent":  0,
 	}
 
-	block, err := databaseManager.AddBlock(userID, blockData, "")
+	// The workspace and its owner's privilege are created together or not at all
+	var block map[string]interface{}
+	err := databaseManager.WithTx(ctx, func(tx *services.Tx) error {
+		var err error
+		if block, err = tx.AddBlock(ctx, userID, blockData, ""); err != nil {
+			return err
+		}
+		privileges := []string{"admin"}
+		return tx.AddMeta(ctx, "workspace", block["id"].(int64), fmt.Sprintf("privilege_%d", userID), privileges)
+	})
 
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -220,9 +273,6 @@ func (ac *ApiController) AddNewWorkspace(c *gin.Context) {
 		return
 	}
 
-	privileges := []string{"admin"}
-	databaseManager.AddMeta("workspace", block["id"].(int64), fmt.Sprintf("privilege_%d", userID), privileges)
-
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
 		"block":  block,
@@ -230,6 +280,7 @@ func (ac *ApiController) AddNewWorkspace(c *gin.Context) {
 }
 
 func (ac *ApiController) DeleteWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 
@@ -241,7 +292,7 @@ func (ac *ApiController) DeleteWorkspace(c *gin.Context) {
 		return
 	}
 
-	databaseManager, dErr := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
 	if dErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status": "fail",
@@ -249,25 +300,13 @@ func (ac *ApiController) DeleteWorkspace(c *gin.Context) {
 		return
 	}
 
-	userID := databaseManager.GetCurrentUser()
-	privileges := string("")
-	privileges, Merr := databaseManager.GetMeta("workspace", content.ID, fmt.Sprintf("privilege_%d", userID))
-	if Merr != nil {
-		//do nothing
-	}
-
-	// Note: deleteBlock implementation needed
 	var deleted bool
-	if privileges != "" {
-		var privArray []string
-		json.Unmarshal([]byte(privileges), &privArray)
-		if contains(privArray, "admin") {
-			err := databaseManager.DeleteBlock(content.ID)
-			if err == nil {
-				deleted = true
-			} else {
-				deleted = false
-			}
+	if hasPrivilege(ctx, databaseManager, "workspace", content.ID, "admin") {
+		err := databaseManager.DeleteBlock(ctx, content.ID)
+		if err == nil {
+			deleted = true
+		} else {
+			deleted = false
 		}
 	}
 
@@ -285,13 +324,47 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// failWithError responds with field errors for validation failures and a
+// plain failure otherwise
+func failWithError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "fail",
+			"errors": validationErr.Fields,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "fail"})
+}
+
+// getPrivileges returns the privileges the current user holds on a parent
+func getPrivileges(ctx context.Context, databaseManager *services.DatabaseManager, parent string, parentID int64) []string {
+	userID := databaseManager.GetCurrentUser()
+	if userID == 0 {
+		return nil
+	}
+
+	privileges, _, err := services.GetMetaJSON[[]string](ctx, databaseManager, parent, parentID, fmt.Sprintf("privilege_%d", userID))
+	if err != nil {
+		return nil
+	}
+	return privileges
+}
+
+// hasPrivilege reports whether the current user holds a privilege on a parent
+func hasPrivilege(ctx context.Context, databaseManager *services.DatabaseManager, parent string, parentID int64, privilege string) bool {
+	return contains(getPrivileges(ctx, databaseManager, parent, parentID), privilege)
+}
+
 func (ac *ApiController) GetWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 	slug := c.Param("slug")
 	page := c.Param("page")
 
-	databaseManager, dErr := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
 	if dErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -303,11 +376,13 @@ func (ac *ApiController) GetWorkspace(c *gin.Context) {
 	userID := databaseManager.GetCurrentUser()
 
 	if slug != "" {
-		workspace, err := databaseManager.GetBlock(userID, "workspace", 0, slug, 0)
+		workspace, err := databaseManager.GetBlock(ctx, userID, "workspace", 0, slug, 0)
 		if workspace != nil && err == nil {
+			settings, _ := databaseManager.GetSettings(ctx, workspace["id"].(int64))
 			c.JSON(http.StatusOK, gin.H{
 				"status":    "success",
 				"workspace": workspace,
+				"settings":  settings,
 				"page":      page,
 				"limit":     20,
 				"threads":   []map[string]interface{}{},
@@ -322,19 +397,47 @@ func (ac *ApiController) GetWorkspace(c *gin.Context) {
 	}
 }
 
+// GetThreads lists the threads of a workspace, newest first unless sorted
+// otherwise
 func (ac *ApiController) GetThreads(c *gin.Context) {
-	//domain := c.GetHeader("X-Vuedoo-Domain")
-	//accessKey := c.GetHeader("X-Vuedoo-Access-Key")
-	//slug := c.Param("slug")
-	//page := c.Param("page")
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	page, pErr := strconv.Atoi(c.Param("page"))
+	if pErr != nil || page < 1 {
+		page = 1
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	listOptions, lErr := services.ParseListOptions(c.Request.URL.Query())
+	if lErr != nil {
+		failWithError(c, lErr)
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "threads": nil})
+		return
+	}
+	workspace := findWorkspace(c, databaseManager, false)
+	if workspace == nil {
+		return
+	}
+
+	threads, err := databaseManager.GetChildBlocks(ctx, "thread", workspace.ID, page, listOptions)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "threads": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, withPagination(gin.H{
 		"status":  "success",
-		"threads": []map[string]interface{}{},
-	})
+		"threads": threads.Blocks,
+	}, threads, listOptions))
 }
 
 func (ac *ApiController) UpdateWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
 	domain := c.GetHeader("X-Vuedoo-Domain")
 	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
 	slug := c.Param("slug")
@@ -351,9 +454,7 @@ func (ac *ApiController) UpdateWorkspace(c *gin.Context) {
 		return
 	}
 
-	fmt.Println("UpdateWorkspace - request:", request)
-
-	databaseManager, dErr := services.NewDatabaseManager(ac.db, domain, accessKey)
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
 	if dErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "fail",
@@ -365,9 +466,12 @@ func (ac *ApiController) UpdateWorkspace(c *gin.Context) {
 	userID := databaseManager.GetCurrentUser()
 
 	if slug != "" {
-		workspace, err := databaseManager.GetBlock(userID, "workspace", 0, slug, 0)
+		workspace, err := databaseManager.GetBlock(ctx, userID, "workspace", 0, slug, 0)
 		if workspace != nil && err == nil {
-			databaseManager.AddMeta("workspace", workspace["id"].(int64), "stripe_secret_key", request.Stripe_secret_key)
+			if err := databaseManager.AddMeta(ctx, "workspace", workspace["id"].(int64), "stripe_secret_key", request.Stripe_secret_key); err != nil {
+				failWithError(c, err)
+				return
+			}
 		}
 	}
 
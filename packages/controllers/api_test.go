@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/database"
+	"github.com/miumoin/agencybot/packages/migrations"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+const testDomain = "test.local"
+
+// testAPI serves the API routes from a migrated in-memory SQLite database
+type testAPI struct {
+	t      *testing.T
+	db     *database.DB
+	stores services.Stores
+	router *gin.Engine
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := services.NewSQLStores(db)
+	router := gin.New()
+	NewApiController(db, stores, router).RegisterApiRoutes()
+	return &testAPI{t: t, db: db, stores: stores, router: router}
+}
+
+// addUser creates a user of the test system and returns it with its access key
+func (a *testAPI) addUser(email string) services.User {
+	a.t.Helper()
+	ctx := context.Background()
+	systemID, err := a.stores.Systems.FindSystem(ctx, testDomain)
+	if err == nil && systemID == 0 {
+		systemID, err = a.stores.Systems.AddSystem(ctx, testDomain)
+	}
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	u := services.User{SystemID: systemID, Email: email, AccessKey: "key-" + email}
+	if u.ID, err = a.stores.Users.AddUser(ctx, u); err != nil {
+		a.t.Fatal(err)
+	}
+	return u
+}
+
+// request sends body as JSON with the user's access key and decodes the
+// JSON response
+func (a *testAPI) request(method, path, accessKey string, body interface{}) (int, map[string]interface{}) {
+	a.t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vuedoo-Domain", testDomain)
+	if accessKey != "" {
+		req.Header.Set("X-Vuedoo-Access-Key", accessKey)
+	}
+
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		a.t.Fatalf("%s %s: response is not JSON: %s", method, path, w.Body.String())
+	}
+	return w.Code, response
+}
+
+func TestVerifyAcceptsOnlyTheExactCode(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.addUser("ada@example.com")
+	value := fmt.Sprintf(`{"code":"123456","timestamp":%q}`, time.Now().Format(time.RFC3339))
+	if err := api.stores.Metas.SetMeta(context.Background(), "user", user.ID, "validation_key", value); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, code := range []string{"%", "______", "12345", ""} {
+		_, response := api.request(http.MethodPost, "/api/verify", "", gin.H{"code": code})
+		if response["status"] != "fail" || response["access_key"] != "" {
+			t.Errorf("code %q: got %v, want fail", code, response)
+		}
+	}
+
+	_, response := api.request(http.MethodPost, "/api/verify", "", gin.H{"code": "123456"})
+	if response["status"] != "success" || response["access_key"] != user.AccessKey {
+		t.Errorf("exact code: got %v, want success with the user's access key", response)
+	}
+}
+
+func TestAddAndListWorkspaces(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.addUser("owner@example.com")
+	other := api.addUser("other@example.com")
+
+	_, response := api.request(http.MethodPost, "/api/workspaces/add", owner.AccessKey, gin.H{"title": "Support"})
+	if response["status"] != "success" {
+		t.Fatalf("adding a workspace: %v", response)
+	}
+
+	_, response = api.request(http.MethodGet, "/api/workspaces", owner.AccessKey, nil)
+	workspaces, _ := response["workspaces"].([]interface{})
+	if response["status"] != "success" || len(workspaces) != 1 {
+		t.Fatalf("owner's workspaces: %v", response)
+	}
+
+	_, response = api.request(http.MethodGet, "/api/workspaces", other.AccessKey, nil)
+	if workspaces, _ := response["workspaces"].([]interface{}); response["status"] != "success" || len(workspaces) != 0 {
+		t.Errorf("another user's workspaces: got %v, want none", response)
+	}
+
+	_, response = api.request(http.MethodGet, "/api/workspaces", "unknown", nil)
+	if response["status"] != "fail" {
+		t.Errorf("unknown access key: got %v, want fail", response)
+	}
+}
@@ -0,0 +1,488 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+const blocksPerPage = 20
+
+// blockPayload is the request body accepted when creating or updating a block
+type blockPayload struct {
+	Title   *string                `json:"title"`
+	Content *string                `json:"content"`
+	Parent  int64                  `json:"parent"`
+	Metas   map[string]interface{} `json:"metas"`
+}
+
+// blockAccess reports whether the current user can read and write a block.
+// Access is granted through the top-level block that owns it.
+func blockAccess(ctx context.Context, databaseManager *services.DatabaseManager, block *services.Block) (bool, bool) {
+	userID := databaseManager.GetCurrentUser()
+	if userID == 0 || block == nil {
+		return false, false
+	}
+
+	root, err := databaseManager.GetRootBlock(ctx, block.ID)
+	if err != nil || root == nil {
+		return false, false
+	}
+	if int64(root.Author) == userID {
+		return true, true
+	}
+
+	privileges := getPrivileges(ctx, databaseManager, root.Type, root.ID)
+	canWrite := contains(privileges, "admin") || contains(privileges, "editor")
+	return len(privileges) > 0, canWrite
+}
+
+// publicMetas drops metas that must not leave the server through the block API
+func publicMetas(metas map[string]string) map[string]string {
+	out := make(map[string]string, len(metas))
+	for k, v := range metas {
+		if strings.HasPrefix(k, "privilege_") {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func validateMetaKeys(metas map[string]interface{}) error {
+	for k := range metas {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("meta keys must not be empty")
+		}
+		if strings.HasPrefix(k, "privilege_") {
+			return fmt.Errorf("meta %q is reserved", k)
+		}
+	}
+	return nil
+}
+
+// blockResponse renders a block together with its metas
+func blockResponse(ctx context.Context, databaseManager *services.DatabaseManager, block *services.Block) map[string]interface{} {
+	result := services.BlockToMap(block)
+	metas, err := databaseManager.GetMetas(ctx, block.ID, block.Type, []string{})
+	if err != nil || metas == nil {
+		metas = map[string]string{}
+	}
+	result["metas"] = publicMetas(metas)
+	return result
+}
+
+// blockETag is the entity tag of a block's current version
+func blockETag(block *services.Block) string {
+	return fmt.Sprintf("%q", strconv.FormatInt(block.Version, 10))
+}
+
+// ifMatchVersion reads the block version from an If-Match header. It returns
+// 0 when the header is missing or "*", and ok is false when it is malformed.
+func ifMatchVersion(c *gin.Context) (version int64, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// respondVersionConflict answers a failed conditional update with 412 and the
+// block as it is now, so the client can merge its changes
+func respondVersionConflict(c *gin.Context, databaseManager *services.DatabaseManager, block *services.Block) {
+	ctx := c.Request.Context()
+	current, err := databaseManager.FindBlock(ctx, block.Type, block.ID, "")
+	if err != nil || current == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"status": "fail", "message": services.ErrVersionConflict.Error()})
+		return
+	}
+
+	c.Header("ETag", blockETag(current))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"status":  "fail",
+		"message": services.ErrVersionConflict.Error(),
+		"version": current.Version,
+		"block":   blockResponse(ctx, databaseManager, current),
+	})
+}
+
+// findAccessibleBlock loads the block named by the :type and :slug route
+// params. It responds and returns nil when the block is missing or the current
+// user lacks the required access.
+func findAccessibleBlock(c *gin.Context, databaseManager *services.DatabaseManager, needWrite bool) *services.Block {
+	ctx := c.Request.Context()
+	blockType := c.Param("type")
+	if _, ok := services.GetBlockTypeDefinition(blockType); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return nil
+	}
+
+	block, err := databaseManager.FindBlock(ctx, blockType, 0, c.Param("slug"))
+	if err != nil || block == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return nil
+	}
+
+	canRead, canWrite := blockAccess(ctx, databaseManager, block)
+	if !canRead || (needWrite && !canWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return nil
+	}
+	return block
+}
+
+// ListBlockTypes returns the registered block type schemas
+func (ac *ApiController) ListBlockTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"types":  services.BlockTypeDefinitions(),
+	})
+}
+
+func (ac *ApiController) ListBlocks(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+	page, pErr := strconv.Atoi(c.Query("page"))
+	if pErr != nil || page < 1 {
+		page = 1
+	}
+
+	def, ok := services.GetBlockTypeDefinition(blockType)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	listOptions, lErr := services.ParseListOptions(c.Request.URL.Query())
+	if lErr != nil {
+		failWithError(c, lErr)
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	userID := databaseManager.GetCurrentUser()
+
+	var blocks *services.BlockPage
+	var err error
+	if def.IsTopLevel() {
+		blocks, err = databaseManager.GetBlocks(ctx, userID, blockType, page, 0, listOptions)
+	} else {
+		parentID, _ := strconv.ParseInt(c.Query("parent"), 10, 64)
+		if parentID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "parent is required"})
+			return
+		}
+
+		parent, fErr := databaseManager.FindBlock(ctx, "", parentID, "")
+		if fErr != nil || parent == nil {
+			c.JSON(http.StatusNotFound, gin.H{"status": "fail", "blocks": nil})
+			return
+		}
+		if canRead, _ := blockAccess(ctx, databaseManager, parent); !canRead {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "blocks": nil})
+			return
+		}
+
+		blocks, err = databaseManager.GetChildBlocks(ctx, blockType, parent.ID, page, listOptions)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, withPagination(gin.H{
+		"status": "success",
+		"blocks": blocks.Blocks,
+		"page":   page,
+	}, blocks, listOptions))
+}
+
+// withPagination adds limit, next_cursor and, when requested, total to a list
+// response
+func withPagination(response gin.H, page *services.BlockPage, opts services.ListOptions) gin.H {
+	response["limit"] = opts.Limit
+	response["next_cursor"] = nil
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	if page.Total != nil {
+		response["total"] = *page.Total
+	}
+	return response
+}
+
+func (ac *ApiController) GetTypedBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+	slug := c.Param("slug")
+
+	if _, ok := services.GetBlockTypeDefinition(blockType); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block, err := databaseManager.FindBlock(ctx, blockType, 0, slug)
+	if err != nil || block == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+	if canRead, _ := blockAccess(ctx, databaseManager, block); !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	c.Header("ETag", blockETag(block))
+	if c.GetHeader("If-None-Match") == blockETag(block) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
+
+func (ac *ApiController) CreateBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+
+	def, ok := services.GetBlockTypeDefinition(blockType)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	var payload blockPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+	if err := validateMetaKeys(payload.Metas); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	userID := databaseManager.GetCurrentUser()
+	if userID == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	parentID := int64(0)
+	if !def.IsTopLevel() {
+		parent, err := databaseManager.FindBlock(ctx, "", payload.Parent, "")
+		if err != nil || parent == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "parent not found"})
+			return
+		}
+		if _, canWrite := blockAccess(ctx, databaseManager, parent); !canWrite {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+			return
+		}
+		parentID = parent.ID
+	}
+
+	blockData := map[string]interface{}{
+		"type":    blockType,
+		"title":   "",
+		"content": "",
+		"parent":  parentID,
+		"metas":   payload.Metas,
+	}
+	if payload.Title != nil {
+		blockData["title"] = *payload.Title
+	}
+	if payload.Content != nil {
+		blockData["content"] = *payload.Content
+	}
+
+	// A top-level block and its owner's privilege are created together or not at all
+	var blockID int64
+	err := databaseManager.WithTx(ctx, func(tx *services.Tx) error {
+		created, err := tx.AddBlock(ctx, userID, blockData, "")
+		if err != nil {
+			return err
+		}
+		blockID = created["id"].(int64)
+		if !def.IsTopLevel() {
+			return nil
+		}
+		return tx.AddMeta(ctx, blockType, blockID, fmt.Sprintf("privilege_%d", userID), []string{"admin"})
+	})
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	block, err := databaseManager.FindBlock(ctx, blockType, blockID, "")
+	if err != nil || block == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	c.Header("ETag", blockETag(block))
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
+
+func (ac *ApiController) UpdateBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+	slug := c.Param("slug")
+
+	if _, ok := services.GetBlockTypeDefinition(blockType); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	var payload blockPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+	if err := validateMetaKeys(payload.Metas); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "invalid If-Match header"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block, err := databaseManager.FindBlock(ctx, blockType, 0, slug)
+	if err != nil || block == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+	if _, canWrite := blockAccess(ctx, databaseManager, block); !canWrite {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	blockData := map[string]interface{}{
+		"type":    block.Type,
+		"title":   block.Title,
+		"content": block.Content,
+		"metas":   payload.Metas,
+		"version": version,
+	}
+	if payload.Title != nil {
+		blockData["title"] = *payload.Title
+	}
+	if payload.Content != nil {
+		blockData["content"] = *payload.Content
+	}
+
+	if _, err := databaseManager.AddBlock(ctx, databaseManager.GetCurrentUser(), blockData, block.Slug); err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			respondVersionConflict(c, databaseManager, block)
+			return
+		}
+		failWithError(c, err)
+		return
+	}
+
+	block, err = databaseManager.FindBlock(ctx, blockType, block.ID, "")
+	if err != nil || block == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	c.Header("ETag", blockETag(block))
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
+
+func (ac *ApiController) DeleteBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+	slug := c.Param("slug")
+
+	def, ok := services.GetBlockTypeDefinition(blockType)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block, err := databaseManager.FindBlock(ctx, blockType, 0, slug)
+	if err != nil || block == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return
+	}
+
+	// Removing a top-level block takes the same admin privilege as DeleteWorkspace
+	allowed := false
+	if def.IsTopLevel() {
+		allowed = hasPrivilege(ctx, databaseManager, block.Type, block.ID, "admin")
+	} else {
+		_, allowed = blockAccess(ctx, databaseManager, block)
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	if err := databaseManager.DeleteBlock(ctx, block.ID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
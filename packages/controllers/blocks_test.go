@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failPrivilegeWrites makes every write of a privilege meta fail
+func (a *testAPI) failPrivilegeWrites() {
+	a.t.Helper()
+	if _, err := a.db.Exec(`CREATE TRIGGER fail_privileges BEFORE INSERT ON metas
+WHEN NEW.meta_key LIKE 'privilege!_%' ESCAPE '!'
+BEGIN SELECT RAISE(ABORT, 'privilege writes are disabled'); END`); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// countBlocks returns how many active blocks of a type exist
+func (a *testAPI) countBlocks(blockType string) int {
+	a.t.Helper()
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM blocks WHERE type = ? AND status = 1", blockType).Scan(&count); err != nil {
+		a.t.Fatal(err)
+	}
+	return count
+}
+
+func TestCreateTopLevelBlockFailsWithoutOwnerPrivilege(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.addUser("ada@example.com")
+	api.failPrivilegeWrites()
+
+	_, response := api.request(http.MethodPost, "/api/blocks/workspace", user.AccessKey, gin.H{"title": "Support"})
+	if response["status"] != "fail" {
+		t.Errorf("got %v, want fail", response)
+	}
+	if n := api.countBlocks("workspace"); n != 0 {
+		t.Errorf("%d workspaces were left without an owner", n)
+	}
+}
+
+func TestCreateListAndUpdateBlocks(t *testing.T) {
+	api := newTestAPI(t)
+	owner := api.addUser("owner@example.com")
+	other := api.addUser("other@example.com")
+
+	_, response := api.request(http.MethodPost, "/api/blocks/workspace", owner.AccessKey, gin.H{"title": "Support"})
+	workspace, _ := response["block"].(map[string]interface{})
+	if response["status"] != "success" || workspace == nil {
+		t.Fatalf("creating a workspace: %v", response)
+	}
+	workspaceID := int64(workspace["id"].(float64))
+
+	for _, title := range []string{"First question", "Second question"} {
+		_, response = api.request(http.MethodPost, "/api/blocks/thread", owner.AccessKey, gin.H{"title": title, "parent": workspaceID})
+		if response["status"] != "success" {
+			t.Fatalf("creating thread %q: %v", title, response)
+		}
+	}
+
+	_, response = api.request(http.MethodGet, "/api/blocks/workspace", owner.AccessKey, nil)
+	if blocks, _ := response["blocks"].([]interface{}); response["status"] != "success" || len(blocks) != 1 {
+		t.Errorf("owner's workspaces: %v", response)
+	}
+	_, response = api.request(http.MethodGet, "/api/blocks/workspace", other.AccessKey, nil)
+	if blocks, _ := response["blocks"].([]interface{}); response["status"] != "success" || len(blocks) != 0 {
+		t.Errorf("another user's workspaces: got %v, want none", response)
+	}
+
+	path := fmt.Sprintf("/api/blocks/thread?parent=%d", workspaceID)
+	_, response = api.request(http.MethodGet, path, owner.AccessKey, nil)
+	if blocks, _ := response["blocks"].([]interface{}); response["status"] != "success" || len(blocks) != 2 {
+		t.Errorf("threads of the workspace: %v", response)
+	}
+	if code, _ := api.request(http.MethodGet, path, other.AccessKey, nil); code != http.StatusForbidden {
+		t.Errorf("another user listing the threads: got %d, want 403", code)
+	}
+
+	path = "/api/blocks/workspace/" + workspace["slug"].(string)
+	if code, _ := api.request(http.MethodPut, path, other.AccessKey, gin.H{"title": "Taken"}); code != http.StatusForbidden {
+		t.Errorf("another user updating the workspace: got %d, want 403", code)
+	}
+	_, response = api.request(http.MethodPut, path, owner.AccessKey, gin.H{"title": "Customer support"})
+	updated, _ := response["block"].(map[string]interface{})
+	if response["status"] != "success" || updated == nil || updated["title"] != "Customer support" {
+		t.Fatalf("updating the workspace: %v", response)
+	}
+
+	_, response = api.request(http.MethodGet, path, owner.AccessKey, nil)
+	if block, _ := response["block"].(map[string]interface{}); block == nil || block["title"] != "Customer support" {
+		t.Errorf("workspace after the update: %v", response)
+	}
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+func (ac *ApiController) GetTemplates(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil || databaseManager.GetCurrentUser() == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "templates": nil})
+		return
+	}
+
+	templates, err := databaseManager.GetTemplates(ctx)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "templates": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"templates": templates,
+	})
+}
+
+// CloneBlock copies a block and its subtree. Templates of the current system
+// can be cloned by any of its users; other blocks need read access.
+func (ac *ApiController) CloneBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	blockType := c.Param("type")
+
+	def, ok := services.GetBlockTypeDefinition(blockType)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	var content struct {
+		Parent  int64    `json:"parent"`
+		Title   string   `json:"title"`
+		Exclude []string `json:"exclude"`
+	}
+	if err := c.ShouldBindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	userID := databaseManager.GetCurrentUser()
+	if userID == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	source, err := databaseManager.FindBlock(ctx, blockType, 0, c.Param("slug"))
+	if err != nil || source == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+	if canRead, _ := blockAccess(ctx, databaseManager, source); !canRead {
+		if isTemplate, _ := databaseManager.IsTemplate(ctx, source); !isTemplate {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+			return
+		}
+	}
+
+	if !def.IsTopLevel() {
+		parent, err := databaseManager.FindBlock(ctx, "", content.Parent, "")
+		if err != nil || parent == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "errors": gin.H{"parent": "not found"}})
+			return
+		}
+		if _, canWrite := blockAccess(ctx, databaseManager, parent); !canWrite {
+			c.JSON(http.StatusForbidden, gin.H{"status": "fail", "block": nil})
+			return
+		}
+	}
+
+	// A top-level copy and its owner's privilege are created together or not at all
+	var block *services.Block
+	err = databaseManager.WithTx(ctx, func(tx *services.Tx) error {
+		var err error
+		block, err = tx.CloneBlock(ctx, userID, source.ID, services.CloneOptions{
+			Parent:       content.Parent,
+			Title:        content.Title,
+			ExcludeTypes: content.Exclude,
+		})
+		if err != nil || block == nil || !def.IsTopLevel() {
+			return err
+		}
+		return tx.AddMeta(ctx, blockType, block.ID, fmt.Sprintf("privilege_%d", userID), []string{"admin"})
+	})
+	if err != nil || block == nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCloneTopLevelBlock(t *testing.T) {
+	api := newTestAPI(t)
+	user := api.addUser("ada@example.com")
+
+	_, response := api.request(http.MethodPost, "/api/blocks/workspace", user.AccessKey, gin.H{"title": "Support"})
+	if response["status"] != "success" {
+		t.Fatalf("creating the source: %v", response)
+	}
+	slug := response["block"].(map[string]interface{})["slug"].(string)
+
+	_, response = api.request(http.MethodPost, "/api/blocks/workspace/"+slug+"/clone", user.AccessKey, gin.H{"title": "Copy"})
+	if response["status"] != "success" {
+		t.Fatalf("cloning: %v", response)
+	}
+	copySlug := response["block"].(map[string]interface{})["slug"].(string)
+
+	// The copy's owner can read it
+	_, response = api.request(http.MethodGet, "/api/blocks/workspace/"+copySlug, user.AccessKey, nil)
+	if response["status"] != "success" {
+		t.Errorf("reading the copy: %v", response)
+	}
+
+	api.failPrivilegeWrites()
+	_, response = api.request(http.MethodPost, "/api/blocks/workspace/"+slug+"/clone", user.AccessKey, gin.H{"title": "Orphan"})
+	if response["status"] != "fail" {
+		t.Errorf("cloning without the owner's privilege: got %v, want fail", response)
+	}
+	if n := api.countBlocks("workspace"); n != 2 {
+		t.Errorf("%d workspaces, want the source and the first copy only", n)
+	}
+}
@@ -16,6 +16,14 @@ func (ac *ApiController) RegisterHomeRoutes() {
 	// Serve static files from /static
 	ac.router.Static("/static", filepath.Join(staticDir, "static"))
 
+	// Serve user uploads such as workspace logos. Browsers must not sniff them
+	// into another type or run anything they contain.
+	uploads := ac.router.Group("/uploads", func(c *gin.Context) {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	})
+	uploads.Static("/", uploadDir())
+
 	// SPA Fallback: Serve index.html for all non-API routes
 	ac.router.NoRoute(func(c *gin.Context) {
 		path := c.Request.URL.Path
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+func (ac *ApiController) GetBlockLinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	incoming := c.Query("direction") == "in"
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "links": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	links, err := databaseManager.GetLinks(ctx, block.ID, c.Query("relation"), incoming)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "links": nil})
+		return
+	}
+
+	// Only show links whose other end the user may read
+	visible := []services.BlockLink{}
+	for _, link := range links {
+		if canRead, _ := blockAccess(ctx, databaseManager, link.OtherBlock()); canRead {
+			visible = append(visible, link)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"links":  visible,
+	})
+}
+
+func (ac *ApiController) AddBlockLink(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var content struct {
+		Target   int64                  `json:"target"`
+		Relation string                 `json:"relation"`
+		Position int                    `json:"position"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := c.BindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "link": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	target, err := databaseManager.FindBlock(ctx, "", content.Target, "")
+	if err != nil || target == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "errors": gin.H{"target": "not found"}})
+		return
+	}
+	if canRead, _ := blockAccess(ctx, databaseManager, target); !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail", "link": nil})
+		return
+	}
+
+	link, err := databaseManager.AddLink(ctx, block.ID, target.ID, content.Relation, content.Position, content.Metadata)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"link":   link,
+	})
+}
+
+func (ac *ApiController) RemoveBlockLink(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	targetID, tErr := strconv.ParseInt(c.Param("target"), 10, 64)
+	if tErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	if err := databaseManager.RemoveLink(ctx, block.ID, targetID, c.Param("relation")); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// UpdateBlockMetas sets several metas of a block at once. A null value deletes
+// the meta.
+func (ac *ApiController) UpdateBlockMetas(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var metas map[string]interface{}
+	if err := c.ShouldBindJSON(&metas); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+	if err := validateMetaKeys(metas); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	if err := databaseManager.SetMetas(ctx, block.Type, block.ID, metas); err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
+
+func (ac *ApiController) IncrementBlockMeta(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	key := c.Param("key")
+
+	var content struct {
+		By *float64 `json:"by"`
+	}
+	// the body is optional and defaults to incrementing by one
+	if err := c.ShouldBindJSON(&content); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+	if err := validateMetaKeys(map[string]interface{}{key: nil}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	by := 1.0
+	if content.By != nil {
+		by = *content.By
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	value, err := databaseManager.IncrementMeta(ctx, block.Type, block.ID, key, by)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"key":    key,
+		"value":  value,
+	})
+}
+
+// GetBlockMetaPath reads a meta, or with ?path= a field inside a JSON meta
+func (ac *ApiController) GetBlockMetaPath(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	key := c.Param("key")
+
+	if err := validateMetaKeys(map[string]interface{}{key: nil}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	path, err := services.ParseMetaPath(c.Query("path"))
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	var value interface{}
+	var found bool
+	if len(path) == 0 {
+		raw, gErr := databaseManager.GetMeta(ctx, block.Type, block.ID, key)
+		value, found, err = raw, raw != "", gErr
+	} else {
+		value, found, err = databaseManager.GetMetaPath(ctx, block.Type, block.ID, key, path)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"key":    key,
+		"path":   c.Query("path"),
+		"value":  value,
+	})
+}
+
+// PatchBlockMetaPath sets one field inside a JSON meta. A null value removes
+// the field.
+func (ac *ApiController) PatchBlockMetaPath(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	key := c.Param("key")
+
+	var content struct {
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+	if err := validateMetaKeys(map[string]interface{}{key: nil}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": err.Error()})
+		return
+	}
+	path, err := services.ParseMetaPath(content.Path)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	document, err := databaseManager.PatchMetaPath(ctx, block.Type, block.ID, key, path, content.Value)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"key":    key,
+		"value":  document,
+	})
+}
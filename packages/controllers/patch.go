@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// PatchBlock applies an RFC 7396 merge patch (application/merge-patch+json or
+// application/json) or an RFC 6902 JSON Patch (application/json-patch+json) to
+// a block document of the form {"title", "content", "metas"}.
+func (ac *ApiController) PatchBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	var mergePatch interface{}
+	var jsonPatch []services.PatchOperation
+	switch mediaType {
+	case "application/json-patch+json":
+		if err := c.ShouldBindJSON(&jsonPatch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "invalid JSON Patch document"})
+			return
+		}
+	case "application/merge-patch+json", "application/json", "":
+		if err := c.ShouldBindJSON(&mergePatch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "invalid merge patch document"})
+			return
+		}
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"status": "fail"})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "message": "invalid If-Match header"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	document, err := databaseManager.BlockDocument(ctx, block)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	var patched interface{}
+	if jsonPatch != nil {
+		patched, err = services.ApplyJSONPatch(document, jsonPatch)
+		if err != nil {
+			failWithError(c, err)
+			return
+		}
+	} else {
+		patched = services.ApplyMergePatch(document, mergePatch)
+	}
+
+	if err := databaseManager.PatchBlock(ctx, databaseManager.GetCurrentUser(), block, patched, version); err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			respondVersionConflict(c, databaseManager, block)
+			return
+		}
+		failWithError(c, err)
+		return
+	}
+
+	block, err = databaseManager.FindBlock(ctx, block.Type, block.ID, "")
+	if err != nil || block == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	c.Header("ETag", blockETag(block))
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// findWorkspace loads the workspace named by :slug and checks the current user
+// can read it, or write it when needWrite is set. It responds and returns nil
+// on failure.
+func findWorkspace(c *gin.Context, databaseManager *services.DatabaseManager, needWrite bool) *services.Block {
+	ctx := c.Request.Context()
+	workspace, err := databaseManager.FindBlock(ctx, "workspace", 0, c.Param("slug"))
+	if err != nil || workspace == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return nil
+	}
+	canRead, canWrite := blockAccess(ctx, databaseManager, workspace)
+	if !canRead || (needWrite && !canWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return nil
+	}
+	return workspace
+}
+
+// retrieve runs a hybrid retrieval over the workspace's chunks. The body is
+// {query, embedding, limit}; embedding is optional when an embedder is set up.
+func (ac *ApiController) retrieve(c *gin.Context, debug bool) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var content struct {
+		Query     string    `json:"query"`
+		Embedding []float64 `json:"embedding"`
+		Limit     int       `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "results": nil})
+		return
+	}
+	workspace := findWorkspace(c, databaseManager, false)
+	if workspace == nil {
+		return
+	}
+
+	results, weights, err := databaseManager.Retrieve(ctx, workspace.ID, content.Query, content.Embedding, content.Limit)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	if debug {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"results": results,
+			"weights": weights,
+		})
+		return
+	}
+
+	chunks := make([]gin.H, 0, len(results))
+	for _, r := range results {
+		chunks = append(chunks, gin.H{"chunk": r.Chunk, "score": r.Score})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"results": chunks,
+	})
+}
+
+// Retrieve returns the workspace chunks that best match a query
+func (ac *ApiController) Retrieve(c *gin.Context) {
+	ac.retrieve(c, false)
+}
+
+// RetrieveDebug is Retrieve with each chunk's keyword and vector scores and
+// ranks and the weights used to fuse them
+func (ac *ApiController) RetrieveDebug(c *gin.Context) {
+	ac.retrieve(c, true)
+}
+
+func (ac *ApiController) GetRetrievalWeights(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "weights": nil})
+		return
+	}
+	workspace := findWorkspace(c, databaseManager, false)
+	if workspace == nil {
+		return
+	}
+
+	weights, err := databaseManager.GetRetrievalWeights(ctx, workspace.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "weights": nil})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "weights": weights})
+}
+
+func (ac *ApiController) UpdateRetrievalWeights(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	weights := services.DefaultRetrievalWeights
+	if err := c.ShouldBindJSON(&weights); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "weights": nil})
+		return
+	}
+	workspace := findWorkspace(c, databaseManager, true)
+	if workspace == nil {
+		return
+	}
+
+	if err := databaseManager.SetRetrievalWeights(ctx, workspace.ID, weights); err != nil {
+		failWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "weights": weights})
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (ac *ApiController) GetBlockRevisions(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "revisions": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	revisions, err := databaseManager.GetRevisions(ctx, block.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "revisions": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"revisions": revisions,
+	})
+}
+
+func (ac *ApiController) GetBlockRevision(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	number, nErr := strconv.Atoi(c.Param("revision"))
+	if nErr != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "revision": nil})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "revision": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	revision, err := databaseManager.GetRevision(ctx, block.ID, number)
+	if err != nil || revision == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "revision": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"revision": revision,
+	})
+}
+
+func (ac *ApiController) RestoreBlockRevision(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	number, nErr := strconv.Atoi(c.Param("revision"))
+	if nErr != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	restored, err := databaseManager.RestoreRevision(ctx, databaseManager.GetCurrentUser(), block.ID, number)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+	if restored == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	block, err = databaseManager.FindBlock(ctx, block.Type, block.ID, "")
+	if err != nil || block == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "block": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// Search runs a full-text query over the blocks the current user can read:
+// ?q=&types=&parent=&page=&limit=
+func (ac *ApiController) Search(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	page, pErr := strconv.Atoi(c.Query("page"))
+	if pErr != nil || page < 1 {
+		page = 1
+	}
+	limit, lErr := strconv.Atoi(c.Query("limit"))
+	if lErr != nil || limit < 1 {
+		limit = blocksPerPage
+	}
+	if limit > services.MaxSearchPerPage {
+		limit = services.MaxSearchPerPage
+	}
+	parentID, _ := strconv.ParseInt(c.Query("parent"), 10, 64)
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil || databaseManager.GetCurrentUser() == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "results": nil})
+		return
+	}
+
+	results, err := databaseManager.Search(ctx, services.SearchQuery{
+		Text:    c.Query("q"),
+		Types:   typesQuery(c),
+		Parent:  parentID,
+		Page:    page,
+		PerPage: limit,
+	})
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"results": results.Hits,
+		"total":   results.Total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+const maxLogoSize = 2 << 20
+
+// logoExtensions maps the types http.DetectContentType finds in accepted
+// logos to their file extension. SVG is not accepted because it can carry
+// scripts.
+var logoExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// uploadDir returns the directory user uploads are written to and served from
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "./uploads"
+}
+
+func (ac *ApiController) GetSystemSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "fail",
+			"settings": nil,
+		})
+		return
+	}
+
+	settings, err := databaseManager.GetSettings(ctx, 0)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "fail",
+			"settings": nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"settings": settings,
+		"schema":   services.SettingDefinitions(),
+	})
+}
+
+func (ac *ApiController) UpdateSystemSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var values map[string]interface{}
+	if err := c.ShouldBindJSON(&values); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	systemID := databaseManager.GetSystemID()
+	if systemID == 0 || !hasPrivilege(ctx, databaseManager, "system", systemID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	ac.saveSettings(c, databaseManager, "system", systemID, values)
+}
+
+func (ac *ApiController) GetWorkspaceSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	slug := c.Param("slug")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "fail",
+			"settings": nil,
+		})
+		return
+	}
+
+	userID := databaseManager.GetCurrentUser()
+	workspace, err := databaseManager.GetBlock(ctx, userID, "workspace", 0, slug, 0)
+	if workspace == nil || err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":   "fail",
+			"settings": nil,
+		})
+		return
+	}
+
+	settings, err := databaseManager.GetSettings(ctx, workspace["id"].(int64))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "fail",
+			"settings": nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"settings": settings,
+		"schema":   services.SettingDefinitions(),
+	})
+}
+
+func (ac *ApiController) UpdateWorkspaceSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	slug := c.Param("slug")
+
+	var values map[string]interface{}
+	if err := c.ShouldBindJSON(&values); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	workspaceID, ok := ac.adminWorkspaceID(ctx, databaseManager, slug)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	ac.saveSettings(c, databaseManager, "workspace", workspaceID, values)
+}
+
+// UploadWorkspaceLogo stores an uploaded image and points the workspace's logo
+// setting at it
+func (ac *ApiController) UploadWorkspaceLogo(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	slug := c.Param("slug")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	workspaceID, ok := ac.adminWorkspaceID(ctx, databaseManager, slug)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxLogoSize+64<<10)
+	file, err := c.FormFile("file")
+	if err != nil || file.Size > maxLogoSize {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	// The type is read from the file itself, not from what the client claims
+	ext, ok := logoExtensions[detectFileType(file)]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	name := fmt.Sprintf("%d-%s%s", workspaceID, services.NewSlug(10), ext)
+	dest := filepath.Join(uploadDir(), "logos", name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+	if err := c.SaveUploadedFile(file, dest); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	logo := "/uploads/logos/" + name
+	if err := databaseManager.UpdateSettings(ctx, "workspace", workspaceID, map[string]interface{}{"logo": logo}); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"logo":   logo,
+	})
+}
+
+// detectFileType sniffs the content type of an uploaded file from its first
+// bytes, or returns "" when it cannot be read
+func detectFileType(file *multipart.FileHeader) string {
+	f, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	return http.DetectContentType(head[:n])
+}
+
+// saveSettings writes validated settings and responds with the resolved values
+func (ac *ApiController) saveSettings(c *gin.Context, databaseManager *services.DatabaseManager, parent string, parentID int64, values map[string]interface{}) {
+	ctx := c.Request.Context()
+	if err := databaseManager.UpdateSettings(ctx, parent, parentID, values); err != nil {
+		failWithError(c, err)
+		return
+	}
+
+	workspaceID := int64(0)
+	if parent == "workspace" {
+		workspaceID = parentID
+	}
+	settings, err := databaseManager.GetSettings(ctx, workspaceID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"settings": settings,
+	})
+}
+
+// adminWorkspaceID resolves a workspace slug the current user administers
+func (ac *ApiController) adminWorkspaceID(ctx context.Context, databaseManager *services.DatabaseManager, slug string) (int64, bool) {
+	if strings.TrimSpace(slug) == "" {
+		return 0, false
+	}
+
+	userID := databaseManager.GetCurrentUser()
+	workspace, err := databaseManager.GetBlock(ctx, userID, "workspace", 0, slug, 0)
+	if workspace == nil || err != nil {
+		return 0, false
+	}
+
+	workspaceID := workspace["id"].(int64)
+	if !hasPrivilege(ctx, databaseManager, "workspace", workspaceID, "admin") {
+		return 0, false
+	}
+	return workspaceID, true
+}
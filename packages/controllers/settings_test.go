@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// createWorkspace creates a workspace owned by the user and returns its slug
+func (a *testAPI) createWorkspace(accessKey, title string) string {
+	a.t.Helper()
+	_, response := a.request(http.MethodPost, "/api/blocks/workspace", accessKey, gin.H{"title": title})
+	if response["status"] != "success" {
+		a.t.Fatalf("creating workspace %q: %v", title, response)
+	}
+	return response["block"].(map[string]interface{})["slug"].(string)
+}
+
+// uploadLogo posts data as the logo file with the given claimed content type
+func (a *testAPI) uploadLogo(accessKey, slug, contentType string, data []byte) (int, map[string]interface{}) {
+	a.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="logo"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/workspace/"+slug+"/logo", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Vuedoo-Domain", testDomain)
+	req.Header.Set("X-Vuedoo-Access-Key", accessKey)
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestUploadWorkspaceLogo(t *testing.T) {
+	t.Setenv("UPLOAD_DIR", t.TempDir())
+	api := newTestAPI(t)
+	NewApiController(api.db, api.stores, api.router).RegisterHomeRoutes()
+	user := api.addUser("ada@example.com")
+	slug := api.createWorkspace(user.AccessKey, "Support")
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+
+	rejected := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"svg", "image/svg+xml", svg},
+		{"html claiming to be png", "image/png", []byte("<html><script>alert(1)</script></html>")},
+		{"too large", "image/png", append(png, make([]byte, maxLogoSize)...)},
+	}
+	for _, tt := range rejected {
+		if code, response := api.uploadLogo(user.AccessKey, slug, tt.contentType, tt.data); code != http.StatusBadRequest || response["status"] == "success" {
+			t.Errorf("%s: got %d %v, want 400", tt.name, code, response)
+		}
+	}
+
+	code, response := api.uploadLogo(user.AccessKey, slug, "application/octet-stream", png)
+	if code != http.StatusOK || response["status"] != "success" {
+		t.Fatalf("png: got %d %v, want success", code, response)
+	}
+	logo := response["logo"].(string)
+	if !strings.HasSuffix(logo, ".png") {
+		t.Errorf("logo %q does not have the detected extension", logo)
+	}
+
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, logo, nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Content-Security-Policy") == "" {
+		t.Errorf("serving the logo: got %d with headers %v", w.Code, w.Header())
+	}
+}
+
+func TestUpdateSystemSettingsAsGrantedAdmin(t *testing.T) {
+	api := newTestAPI(t)
+	admin := api.addUser("admin@example.com")
+	member := api.addUser("member@example.com")
+	if err := services.GrantSystemAdmin(context.Background(), api.stores, testDomain, admin.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := api.request(http.MethodPatch, "/api/settings", member.AccessKey, gin.H{"brand_name": "Acme"})
+	if code != http.StatusForbidden {
+		t.Errorf("member: got %d, want 403", code)
+	}
+
+	code, response := api.request(http.MethodPatch, "/api/settings", admin.AccessKey, gin.H{"brand_name": "Acme"})
+	if code != http.StatusOK || response["status"] != "success" {
+		t.Fatalf("admin: got %d %v, want success", code, response)
+	}
+	settings := response["settings"].(map[string]interface{})
+	if settings["brand_name"] != "Acme" {
+		t.Errorf("brand_name = %v, want Acme", settings["brand_name"])
+	}
+
+	// Granting twice keeps a single admin privilege
+	if err := services.GrantSystemAdmin(context.Background(), api.stores, testDomain, admin.Email); err != nil {
+		t.Fatal(err)
+	}
+	value, _, err := api.stores.Metas.GetMeta(context.Background(), "system", admin.SystemID, fmt.Sprintf("privilege_%d", admin.ID))
+	if err != nil || value != `["admin"]` {
+		t.Errorf("privileges after granting twice = %s, %v, want [\"admin\"]", value, err)
+	}
+	if err := services.GrantSystemAdmin(context.Background(), api.stores, testDomain, "nobody@example.com"); err == nil {
+		t.Error("granting an unknown user succeeded")
+	}
+}
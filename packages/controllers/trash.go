@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// canManageTrashed reports whether the current user may restore or purge a
+// trashed block. The second result is false when the block's parent is itself
+// in the trash and has to be restored first.
+func canManageTrashed(ctx context.Context, databaseManager *services.DatabaseManager, block *services.Block) (bool, bool) {
+	if block.Parent == nil || *block.Parent <= 0 {
+		allowed := int64(block.Author) == databaseManager.GetCurrentUser() ||
+			hasPrivilege(ctx, databaseManager, block.Type, block.ID, "admin")
+		return allowed, true
+	}
+
+	parent, err := databaseManager.FindBlock(ctx, "", *block.Parent, "")
+	if err != nil || parent == nil {
+		return false, false
+	}
+	_, canWrite := blockAccess(ctx, databaseManager, parent)
+	return canWrite, true
+}
+
+func (ac *ApiController) GetTrash(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil || databaseManager.GetCurrentUser() == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	blocks, err := databaseManager.GetTrash(ctx, databaseManager.GetCurrentUser())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"blocks": blocks,
+	})
+}
+
+func (ac *ApiController) GetBlockTrash(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	blocks, err := databaseManager.GetTrashUnder(ctx, block.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "blocks": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"blocks": blocks,
+	})
+}
+
+func (ac *ApiController) RestoreTrashedBlock(c *gin.Context) {
+	ac.manageTrashedBlock(c, func(ctx context.Context, databaseManager *services.DatabaseManager, block *services.Block) error {
+		return databaseManager.RestoreBlock(ctx, block.ID)
+	})
+}
+
+func (ac *ApiController) PurgeTrashedBlock(c *gin.Context) {
+	ac.manageTrashedBlock(c, func(ctx context.Context, databaseManager *services.DatabaseManager, block *services.Block) error {
+		return databaseManager.PurgeBlock(ctx, block.ID)
+	})
+}
+
+// manageTrashedBlock resolves the trashed block named by :slug, checks
+// permissions and runs action on it
+func (ac *ApiController) manageTrashedBlock(c *gin.Context, action func(context.Context, *services.DatabaseManager, *services.Block) error) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil || databaseManager.GetCurrentUser() == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block, err := databaseManager.FindTrashedBlock(ctx, c.Param("slug"))
+	if err != nil || block == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "fail"})
+		return
+	}
+
+	allowed, parentActive := canManageTrashed(ctx, databaseManager, block)
+	if !parentActive {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":  "fail",
+			"message": services.ErrParentTrashed.Error(),
+		})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	if err := action(ctx, databaseManager, block); err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
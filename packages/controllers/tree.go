@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/services"
+)
+
+// typesQuery parses a comma separated ?types= filter
+func typesQuery(c *gin.Context) []string {
+	var types []string
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func (ac *ApiController) GetBlockTree(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+	depth, dErr := strconv.Atoi(c.Query("depth"))
+	if dErr != nil || depth < 1 {
+		depth = 1
+	}
+
+	databaseManager, mErr := ac.databaseManager(ctx, domain, accessKey)
+	if mErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "children": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	children, err := databaseManager.GetSubtree(ctx, block.ID, depth, typesQuery(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "children": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"block":    services.BlockToMap(block),
+		"children": children,
+		"depth":    depth,
+	})
+}
+
+func (ac *ApiController) GetBlockAncestors(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "ancestors": nil})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	ancestors, err := databaseManager.GetAncestors(ctx, block.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "ancestors": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"ancestors": ancestors,
+	})
+}
+
+func (ac *ApiController) CountBlockDescendants(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "count": 0})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, false)
+	if block == nil {
+		return
+	}
+
+	count, err := databaseManager.CountDescendants(ctx, block.ID, typesQuery(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail", "count": 0})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"count":  count,
+	})
+}
+
+func (ac *ApiController) MoveBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var content struct {
+		Parent int64 `json:"parent"`
+	}
+	if err := c.BindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	parent, err := databaseManager.FindBlock(ctx, "", content.Parent, "")
+	if err != nil || parent == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail", "errors": gin.H{"parent": "not found"}})
+		return
+	}
+	if _, canWrite := blockAccess(ctx, databaseManager, parent); !canWrite {
+		c.JSON(http.StatusForbidden, gin.H{"status": "fail"})
+		return
+	}
+
+	if err := databaseManager.MoveBlock(ctx, block.ID, parent.ID); err != nil {
+		if errors.Is(err, services.ErrBlockCycle) {
+			c.JSON(http.StatusConflict, gin.H{"status": "fail", "message": err.Error()})
+			return
+		}
+		failWithError(c, err)
+		return
+	}
+
+	block, err = databaseManager.FindBlock(ctx, block.Type, block.ID, "")
+	if err != nil || block == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
+
+func (ac *ApiController) RepositionBlock(c *gin.Context) {
+	ctx := c.Request.Context()
+	domain := c.GetHeader("X-Vuedoo-Domain")
+	accessKey := c.GetHeader("X-Vuedoo-Access-Key")
+
+	var content struct {
+		After  int64 `json:"after"`
+		Before int64 `json:"before"`
+	}
+	if err := c.BindJSON(&content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "fail"})
+		return
+	}
+
+	databaseManager, dErr := ac.databaseManager(ctx, domain, accessKey)
+	if dErr != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "fail"})
+		return
+	}
+
+	block := findAccessibleBlock(c, databaseManager, true)
+	if block == nil {
+		return
+	}
+
+	position, err := databaseManager.RepositionBlock(ctx, block.ID, content.After, content.Before)
+	if err != nil {
+		failWithError(c, err)
+		return
+	}
+	block.Position = position
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"block":  blockResponse(ctx, databaseManager, block),
+	})
+}
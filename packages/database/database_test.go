@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func openSQLite(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.StatementTimeout = time.Minute
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO items (name) VALUES ('a'), ('b'), ('c')"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// countingCancel records how often the timeout of a query is released
+type countingCancel int
+
+func (c *countingCancel) wrap(cancel context.CancelFunc) context.CancelFunc {
+	return func() {
+		*c++
+		cancel()
+	}
+}
+
+func TestRowsReleaseTheirTimeout(t *testing.T) {
+	db := openSQLite(t)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT name FROM items ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var released countingCancel
+	rows.cancel = released.wrap(rows.cancel)
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if len(names) != 3 {
+		t.Errorf("read %v, want every row before the timeout is released", names)
+	}
+	if released != 1 {
+		t.Errorf("timeout released %d times after the last row, want 1", released)
+	}
+	rows.Close()
+	if released != 2 {
+		t.Errorf("Close did not release the timeout")
+	}
+}
+
+func TestClosingRowsEarlyReleasesTheTimeout(t *testing.T) {
+	db := openSQLite(t)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT name FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var released countingCancel
+	rows.cancel = released.wrap(rows.cancel)
+	rows.Next()
+	rows.Close()
+	if released != 1 {
+		t.Errorf("timeout released %d times, want 1", released)
+	}
+}
+
+func TestRowReleasesItsTimeoutAfterScan(t *testing.T) {
+	db := openSQLite(t)
+
+	row := db.QueryRowContext(context.Background(), "SELECT name FROM items WHERE id = ?", 2)
+	var released countingCancel
+	row.cancel = released.wrap(row.cancel)
+
+	var name string
+	if err := row.Scan(&name); err != nil || name != "b" {
+		t.Fatalf("Scan = %q, %v", name, err)
+	}
+	if released != 1 {
+		t.Errorf("timeout released %d times after Scan, want 1", released)
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect names a supported SQL dialect
+type Dialect string
+
+// Supported dialects
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// returning reports whether new ids are read with INSERT ... RETURNING
+func (d Dialect) returning() bool {
+	return d == Postgres
+}
+
+// TransactionalDDL reports whether schema changes can be rolled back with the
+// transaction they run in. MySQL commits them implicitly.
+func (d Dialect) TransactionalDDL() bool {
+	return d != MySQL
+}
+
+// Rebind rewrites ? placeholders into the dialect's own. Question marks inside
+// quoted strings and identifiers are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Upsert builds an INSERT of columns into table that updates the update
+// columns instead when a row with the same conflict columns exists. The
+// conflict columns must be covered by a unique index.
+func (d Dialect) Upsert(table string, columns, conflict, update []string) string {
+	query := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	sets := make([]string, len(update))
+	if d != MySQL {
+		for i, column := range update {
+			sets[i] = column + " = EXCLUDED." + column
+		}
+		return query + " ON CONFLICT (" + strings.Join(conflict, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	for i, column := range update {
+		sets[i] = column + " = VALUES(" + column + ")"
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// JSONText returns SQL extracting the value at a JSON path from the JSON text
+// in expr as text. The path is bound to the single placeholder and must be
+// built with JSONPath. Text that is not valid JSON gives NULL instead of
+// failing the query; on Postgres the cast goes through try_jsonb, added by
+// migration 0010, which returns NULL where a plain cast would raise.
+func (d Dialect) JSONText(expr string) string {
+	switch d {
+	case Postgres:
+		return "(try_jsonb(" + expr + ") #>> CAST(? AS text[]))"
+	case SQLite:
+		return "(CASE WHEN json_valid(" + expr + ") THEN CAST(json_extract(" + expr + ", ?) AS TEXT) END)"
+	}
+	return "(CASE WHEN JSON_VALID(" + expr + ") THEN JSON_UNQUOTE(JSON_EXTRACT(" + expr + ", ?)) END)"
+}
+
+// JSONPath renders a path of object keys for JSONText
+func (d Dialect) JSONPath(path []string) string {
+	if d == Postgres {
+		return "{" + strings.Join(path, ",") + "}"
+	}
+	return "$." + strings.Join(path, ".")
+}
+
+// CastDecimal returns SQL converting the text in expr to a number for
+// comparisons. Postgres fails the query on text that is not a number, so
+// there only decimal numbers are cast and anything else gives NULL.
+func (d Dialect) CastDecimal(expr string) string {
+	if d == Postgres {
+		return "CAST(SUBSTRING(" + expr + ` FROM '^\s*(-?[0-9]+(?:\.[0-9]+)?)\s*$') AS DECIMAL(30,10))`
+	}
+	return "CAST(" + expr + " AS DECIMAL(30,10))"
+}
+
+// EqualFold returns a condition comparing column to a placeholder without
+// regard to case. MySQL's default collation already ignores case.
+func (d Dialect) EqualFold(column string) string {
+	if d != MySQL {
+		return "LOWER(" + column + ") = LOWER(?)"
+	}
+	return column + " = ?"
+}
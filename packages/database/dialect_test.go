@@ -0,0 +1,40 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostgresGuardsCasts(t *testing.T) {
+	query := Postgres.Rebind("SELECT " + Postgres.JSONText("meta_value") + ", " + Postgres.CastDecimal("meta_value") + " FROM metas WHERE id = ?")
+
+	if !strings.Contains(query, "try_jsonb(meta_value) #>> CAST($1 AS text[])") {
+		t.Errorf("jsonb cast is not guarded: %s", query)
+	}
+	if !strings.Contains(query, `SUBSTRING(meta_value FROM '^\s*(-?[0-9]+(?:\.[0-9]+)?)\s*$')`) {
+		t.Errorf("decimal cast is not guarded: %s", query)
+	}
+	// The ? of the patterns are not placeholders
+	if !strings.Contains(query, "CAST($1 AS text[])") || !strings.Contains(query, "id = $2") {
+		t.Errorf("placeholders were numbered wrongly: %s", query)
+	}
+}
+
+func TestSQLiteGuardsCasts(t *testing.T) {
+	db := openSQLite(t)
+	for value, want := range map[string]string{
+		`{"stage":"lead"}`: "lead",
+		`not json`:         "",
+		`{"stage":`:        "",
+		`{bad`:             "",
+	} {
+		var got *string
+		err := db.QueryRow("SELECT "+SQLite.JSONText("v")+" FROM (SELECT ? AS v)", SQLite.JSONPath([]string{"stage"}), value).Scan(&got)
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		if (got == nil && want != "") || (got != nil && *got != want) {
+			t.Errorf("%q: got %v, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Package migrations applies the versioned database schema embedded in the
+// binary. Migrations live in <dialect>/NNNN_name.up.sql and .down.sql files;
+// applied versions are recorded in schema_migrations with a checksum of their
+// up script so edits to shipped migrations are caught.
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var filePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one schema version with its up and down scripts
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes a migration and whether it has been applied
+type Status struct {
+	Version   int64  `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt string `json:"applied_at,omitempty"`
+	Modified  bool   `json:"modified"` // the embedded script differs from the applied one
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt string
+}
+
+// Migrator applies the migrations of one SQL dialect to a database
+type Migrator struct {
+	db         *database.DB
+	migrations []Migration
+}
+
+// New loads the embedded migrations for the dialect of db
+func New(db *database.DB) (*Migrator, error) {
+	migrations, err := load(files, string(db.Dialect))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %q: %v", dir, err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		m := filePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(data)
+			sum := sha256.Sum256(data)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits a script into statements ending with ";" at the end
+// of a line. Lines starting with "--" are comments.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+func (m *Migrator) ensureTable() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+func (m *Migrator) applied() (map[int64]appliedMigration, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query("SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// verify fails when an applied migration was edited after it ran
+func (m *Migrator) verify(applied map[int64]appliedMigration) error {
+	for _, migration := range m.migrations {
+		if a, ok := applied[migration.Version]; ok && a.checksum != migration.Checksum {
+			return fmt.Errorf("migration %d_%s was modified after it was applied", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := Status{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != migration.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration and returns how many ran
+func (m *Migrator) Up() (int, error) {
+	if len(m.migrations) == 0 {
+		return 0, nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			return m.revert(m.migrations[i])
+		}
+	}
+	return fmt.Errorf("no migration has been applied")
+}
+
+// To applies or reverts migrations until exactly those up to version are
+// applied, and returns how many ran. Version 0 reverts everything.
+func (m *Migrator) To(version int64) (int, error) {
+	known := version == 0
+	for _, migration := range m.migrations {
+		known = known || migration.Version == version
+	}
+	if !known {
+		return 0, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	if err := m.verify(applied); err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err := m.revert(migration); err != nil {
+				return ran, err
+			}
+			ran++
+		}
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := m.apply(migration); err != nil {
+				return ran, err
+			}
+			ran++
+		}
+	}
+	return ran, nil
+}
+
+// execer runs statements on the database or in a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// inTransaction runs fn in a transaction when the dialect can roll back
+// schema changes, so a failing migration leaves nothing behind. MySQL commits
+// DDL implicitly, so there a failing statement can leave earlier statements
+// of the same migration applied.
+func (m *Migrator) inTransaction(fn func(e execer) error) error {
+	if !m.db.Dialect.TransactionalDDL() {
+		return fn(m.db)
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// apply runs an up script and records it as applied
+func (m *Migrator) apply(migration Migration) error {
+	return m.inTransaction(func(e execer) error {
+		for _, statement := range splitStatements(migration.Up) {
+			if _, err := e.Exec(statement); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+		}
+		_, err := e.Exec(
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC().Format("2006-01-02 15:04:05"),
+		)
+		return err
+	})
+}
+
+func (m *Migrator) revert(migration Migration) error {
+	if strings.TrimSpace(migration.Down) == "" {
+		return fmt.Errorf("migration %d_%s cannot be reverted", migration.Version, migration.Name)
+	}
+	return m.inTransaction(func(e execer) error {
+		for _, statement := range splitStatements(migration.Down) {
+			if _, err := e.Exec(statement); err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+		}
+		_, err := e.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		return err
+	})
+}
+
+// Run handles the migrate command: up, down, status or to <version>
+func Run(db *database.DB, args []string, out io.Writer) error {
+	m, err := New(db)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		ran, err := m.Up()
+		fmt.Fprintf(out, "applied %d migrations\n", ran)
+		return err
+	case "down":
+		if err := m.Down(); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "reverted 1 migration")
+		return nil
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate to <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		ran, err := m.To(version)
+		fmt.Fprintf(out, "ran %d migrations\n", ran)
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt
+			}
+			if s.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Fprintf(out, "%04d %-30s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (use up, down, status or to <version>)", command)
+	}
+}
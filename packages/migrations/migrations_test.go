@@ -0,0 +1,124 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+func openSQLite(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUpgradeFromBaselineSchema(t *testing.T) {
+	db := openSQLite(t)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database created from mysqldump.txt before migrations existed
+	for _, statement := range splitStatements(m.migrations[0].Up) {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(
+		"INSERT INTO blocks (type, title, content, author, slug, parent, created_at, modified_at, status) VALUES ('note', 't', 'c', 1, 's', 0, '2024-01-01 00:00:00', '2024-01-01 00:00:00', 1)",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	ran, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran != len(m.migrations) {
+		t.Errorf("Up ran %d migrations, want %d", ran, len(m.migrations))
+	}
+
+	var position string
+	var version int
+	if err := db.QueryRow("SELECT position, version FROM blocks WHERE slug = 's'").Scan(&position, &version); err != nil {
+		t.Fatal(err)
+	}
+	if position != "" || version != 1 {
+		t.Errorf("upgraded block has position %q and version %d, want \"\" and 1", position, version)
+	}
+	for _, table := range []string{"block_revisions", "block_links"} {
+		if _, err := db.Exec("SELECT COUNT(*) FROM " + table); err != nil {
+			t.Errorf("table %s is missing: %v", table, err)
+		}
+	}
+}
+
+func TestDownRevertsEveryMigration(t *testing.T) {
+	db := openSQLite(t)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.To(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT COUNT(*) FROM blocks"); err == nil {
+		t.Error("blocks still exists after reverting every migration")
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up after reverting everything: %v", err)
+	}
+}
+
+func TestFailedMigrationLeavesNothingApplied(t *testing.T) {
+	db := openSQLite(t)
+	migrations, err := load(fstest.MapFS{
+		"sqlite/0001_broken.up.sql": {Data: []byte("CREATE TABLE widgets (id INTEGER);\nINSERT INTO missing VALUES (1);\n")},
+	}, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{db: db, migrations: migrations}
+
+	if _, err := m.Up(); err == nil {
+		t.Fatal("Up succeeded with a failing statement")
+	}
+	if _, err := db.Exec("SELECT COUNT(*) FROM widgets"); err == nil {
+		t.Error("the statement before the failing one was not rolled back")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Applied {
+		t.Error("the failed migration is recorded as applied")
+	}
+}
+
+func TestPostgresFunctionsStayOneStatement(t *testing.T) {
+	migrations, err := load(files, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		if migration.Name != "safe_json_cast" {
+			continue
+		}
+		statements := splitStatements(migration.Up)
+		if len(statements) != 1 || !strings.Contains(statements[0], "EXCEPTION WHEN others THEN RETURN NULL; END $$ LANGUAGE plpgsql") {
+			t.Errorf("try_jsonb was split into %q", statements)
+		}
+		return
+	}
+	t.Error("no safe_json_cast migration for postgres")
+}
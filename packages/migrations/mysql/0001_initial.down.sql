@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS `users`;
+DROP TABLE IF EXISTS `systems`;
+DROP TABLE IF EXISTS `metas`;
+DROP TABLE IF EXISTS `blocks`;
@@ -0,0 +1,12 @@
+ALTER TABLE `users`
+  DROP KEY `user_system`,
+  DROP KEY `user_email`,
+  DROP KEY `user_access_key`;
+
+ALTER TABLE `metas`
+  DROP KEY `meta_key_parent`,
+  DROP KEY `meta_parent`;
+
+ALTER TABLE `blocks`
+  DROP KEY `block_type_author`,
+  DROP KEY `block_parent_status`;
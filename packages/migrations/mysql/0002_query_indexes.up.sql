@@ -0,0 +1,16 @@
+-- Indexes for the lookups in packages/services: children of a parent, blocks
+-- of a type by author, metas of a parent, privilege metas by key and users by
+-- access key or email.
+
+ALTER TABLE `blocks`
+  ADD KEY `block_parent_status` (`parent`,`status`),
+  ADD KEY `block_type_author` (`type`,`status`,`author`);
+
+ALTER TABLE `metas`
+  ADD KEY `meta_parent` (`parent`,`parent_id`,`meta_key`),
+  ADD KEY `meta_key_parent` (`meta_key`,`parent`,`parent_id`);
+
+ALTER TABLE `users`
+  ADD KEY `user_access_key` (`access_key`),
+  ADD KEY `user_email` (`email`),
+  ADD KEY `user_system` (`system_id`);
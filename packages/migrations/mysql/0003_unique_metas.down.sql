@@ -0,0 +1,3 @@
+ALTER TABLE `metas`
+  ADD KEY `meta_parent` (`parent`,`parent_id`,`meta_key`),
+  DROP KEY `meta_parent_key`;
@@ -0,0 +1,14 @@
+-- A parent holds at most one meta per key, so writes can upsert instead of
+-- racing between a lookup and an insert. Duplicates left by such races keep
+-- their latest row.
+
+DELETE m1 FROM `metas` m1
+INNER JOIN `metas` m2
+  ON m1.`parent` = m2.`parent`
+  AND m1.`parent_id` = m2.`parent_id`
+  AND m1.`meta_key` = m2.`meta_key`
+  AND m1.`id` < m2.`id`;
+
+ALTER TABLE `metas`
+  ADD UNIQUE KEY `meta_parent_key` (`parent`,`parent_id`,`meta_key`),
+  DROP KEY `meta_parent`;
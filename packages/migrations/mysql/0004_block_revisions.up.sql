@@ -0,0 +1,16 @@
+-- Revision history of blocks: a snapshot of the title, content and metas of
+-- every version, with the diff from the one before
+
+CREATE TABLE IF NOT EXISTS `block_revisions` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `block_id` int NOT NULL,
+  `revision` int NOT NULL,
+  `author` int NOT NULL,
+  `title` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `content` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `metas` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `diff` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `block_revision` (`block_id`,`revision`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
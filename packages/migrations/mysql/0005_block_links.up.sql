@@ -0,0 +1,15 @@
+-- Typed, ordered links between blocks
+
+CREATE TABLE IF NOT EXISTS `block_links` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `source_id` int NOT NULL,
+  `target_id` int NOT NULL,
+  `relation` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `position` int NOT NULL,
+  `metadata` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+  `author` int NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `block_link` (`source_id`,`target_id`,`relation`),
+  KEY `block_link_target` (`target_id`,`relation`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+ALTER TABLE `blocks`
+  DROP KEY `block_position`,
+  DROP COLUMN `position`;
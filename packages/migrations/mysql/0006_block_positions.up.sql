@@ -0,0 +1,6 @@
+-- Fractional ranks ordering the children of a parent. Existing blocks start
+-- without a rank and sort before ranked ones, by id.
+
+ALTER TABLE `blocks`
+  ADD COLUMN `position` varchar(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL DEFAULT '',
+  ADD KEY `block_position` (`parent`,`type`,`position`);
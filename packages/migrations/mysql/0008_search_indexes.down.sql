@@ -0,0 +1,2 @@
+ALTER TABLE `metas` DROP KEY `meta_search`;
+ALTER TABLE `blocks` DROP KEY `block_search`;
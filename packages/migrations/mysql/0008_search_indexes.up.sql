@@ -0,0 +1,4 @@
+-- Full-text indexes for the sql search backend
+
+ALTER TABLE `blocks` ADD FULLTEXT KEY `block_search` (`title`,`content`);
+ALTER TABLE `metas` ADD FULLTEXT KEY `meta_search` (`meta_value`);
@@ -0,0 +1,8 @@
+-- When a block went to the trash, so later writes do not reset its retention.
+-- Blocks already in the trash count from their last change.
+
+ALTER TABLE `blocks`
+  ADD COLUMN `deleted_at` datetime NULL DEFAULT NULL,
+  ADD KEY `block_deleted` (`status`,`deleted_at`);
+
+UPDATE `blocks` SET `deleted_at` = `modified_at` WHERE `status` <> 1;
@@ -0,0 +1,41 @@
+-- PostgreSQL version of the baseline schema in mysqldump.txt
+
+CREATE TABLE IF NOT EXISTS blocks (
+  id SERIAL PRIMARY KEY,
+  type VARCHAR(255) NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  content TEXT NOT NULL,
+  author INTEGER NOT NULL,
+  slug VARCHAR(120) NOT NULL,
+  parent INTEGER NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  modified_at TIMESTAMP NOT NULL,
+  status INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS block_slug ON blocks (slug);
+
+CREATE TABLE IF NOT EXISTS metas (
+  id SERIAL PRIMARY KEY,
+  parent VARCHAR(35) NOT NULL,
+  parent_id INTEGER NOT NULL,
+  meta_key VARCHAR(120) NOT NULL,
+  meta_value TEXT NOT NULL,
+  status INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS systems (
+  id SERIAL PRIMARY KEY,
+  subdomain VARCHAR(255) NOT NULL,
+  domain VARCHAR(255) NOT NULL,
+  status INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS system_subdomain ON systems (subdomain);
+CREATE UNIQUE INDEX IF NOT EXISTS system_domain ON systems (domain);
+
+CREATE TABLE IF NOT EXISTS users (
+  id SERIAL PRIMARY KEY,
+  system_id INTEGER NOT NULL,
+  email VARCHAR(100) NOT NULL,
+  password VARCHAR(50) NOT NULL,
+  access_key VARCHAR(50) NOT NULL
+);
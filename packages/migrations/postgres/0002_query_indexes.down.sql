@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS system_domain_lower;
+DROP INDEX IF EXISTS system_subdomain_lower;
+DROP INDEX IF EXISTS user_system;
+DROP INDEX IF EXISTS user_email;
+DROP INDEX IF EXISTS user_access_key;
+DROP INDEX IF EXISTS meta_key_parent;
+DROP INDEX IF EXISTS meta_parent;
+DROP INDEX IF EXISTS block_type_author;
+DROP INDEX IF EXISTS block_parent_status;
@@ -0,0 +1,13 @@
+-- A parent holds at most one meta per key, so writes can upsert instead of
+-- racing between a lookup and an insert. Duplicates left by such races keep
+-- their latest row.
+
+DELETE FROM metas m1
+USING metas m2
+WHERE m1.parent = m2.parent
+  AND m1.parent_id = m2.parent_id
+  AND m1.meta_key = m2.meta_key
+  AND m1.id < m2.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS meta_parent_key ON metas (parent, parent_id, meta_key);
+DROP INDEX IF EXISTS meta_parent;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS block_position;
+ALTER TABLE blocks DROP COLUMN IF EXISTS position;
@@ -0,0 +1,6 @@
+-- Fractional ranks ordering the children of a parent. The "C" collation sorts
+-- them byte-wise as they do in MySQL. Existing blocks start without a rank and
+-- sort before ranked ones, by id.
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS position VARCHAR(255) COLLATE "C" NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS block_position ON blocks (parent, type, position);
@@ -0,0 +1,3 @@
+-- Optimistic locking: every write to a block or its metas bumps its version
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS meta_search;
+DROP INDEX IF EXISTS block_search;
@@ -0,0 +1,4 @@
+-- Full-text indexes for the sql search backend
+
+CREATE INDEX IF NOT EXISTS block_search ON blocks USING GIN (to_tsvector('simple', title || ' ' || content));
+CREATE INDEX IF NOT EXISTS meta_search ON metas USING GIN (to_tsvector('simple', meta_value));
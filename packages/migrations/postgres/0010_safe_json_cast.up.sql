@@ -0,0 +1,5 @@
+-- Casts text to jsonb, or NULL when it does not parse, so one malformed meta
+-- value does not abort a whole list query filtering on JSON paths.
+-- The function body stays on one line: statements are split at line ends.
+
+CREATE OR REPLACE FUNCTION try_jsonb(value text) RETURNS jsonb AS $$ BEGIN RETURN CAST(value AS jsonb); EXCEPTION WHEN others THEN RETURN NULL; END $$ LANGUAGE plpgsql IMMUTABLE;
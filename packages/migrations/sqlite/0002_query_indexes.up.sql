@@ -0,0 +1,15 @@
+-- Indexes for the lookups in packages/services: children of a parent, blocks
+-- of a type by author, metas of a parent, privilege metas by key and users by
+-- access key or email. Email and domain lookups ignore case.
+
+CREATE INDEX IF NOT EXISTS block_parent_status ON blocks (parent, status);
+CREATE INDEX IF NOT EXISTS block_type_author ON blocks (type, status, author);
+
+CREATE INDEX IF NOT EXISTS meta_parent ON metas (parent, parent_id, meta_key);
+CREATE INDEX IF NOT EXISTS meta_key_parent ON metas (meta_key, parent, parent_id);
+
+CREATE INDEX IF NOT EXISTS user_access_key ON users (access_key);
+CREATE INDEX IF NOT EXISTS user_email ON users (LOWER(email));
+CREATE INDEX IF NOT EXISTS user_system ON users (system_id);
+CREATE INDEX IF NOT EXISTS system_subdomain_lower ON systems (LOWER(subdomain));
+CREATE INDEX IF NOT EXISTS system_domain_lower ON systems (LOWER(domain));
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS meta_parent ON metas (parent, parent_id, meta_key);
+DROP INDEX IF EXISTS meta_parent_key;
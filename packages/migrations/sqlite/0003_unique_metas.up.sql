@@ -0,0 +1,11 @@
+-- A parent holds at most one meta per key, so writes can upsert instead of
+-- racing between a lookup and an insert. Duplicates left by such races keep
+-- their latest row.
+
+DELETE FROM metas
+WHERE id NOT IN (
+  SELECT MAX(id) FROM metas GROUP BY parent, parent_id, meta_key
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS meta_parent_key ON metas (parent, parent_id, meta_key);
+DROP INDEX IF EXISTS meta_parent;
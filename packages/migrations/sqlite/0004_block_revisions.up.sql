@@ -0,0 +1,15 @@
+-- Revision history of blocks: a snapshot of the title, content and metas of
+-- every version, with the diff from the one before
+
+CREATE TABLE IF NOT EXISTS block_revisions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  block_id INTEGER NOT NULL,
+  revision INTEGER NOT NULL,
+  author INTEGER NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  content TEXT NOT NULL,
+  metas TEXT NOT NULL,
+  diff TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS block_revision ON block_revisions (block_id, revision);
@@ -0,0 +1,14 @@
+-- Typed, ordered links between blocks
+
+CREATE TABLE IF NOT EXISTS block_links (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source_id INTEGER NOT NULL,
+  target_id INTEGER NOT NULL,
+  relation VARCHAR(64) NOT NULL,
+  position INTEGER NOT NULL,
+  metadata TEXT NOT NULL,
+  author INTEGER NOT NULL,
+  created_at TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS block_link ON block_links (source_id, target_id, relation);
+CREATE INDEX IF NOT EXISTS block_link_target ON block_links (target_id, relation);
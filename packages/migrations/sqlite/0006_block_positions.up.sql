@@ -0,0 +1,5 @@
+-- Fractional ranks ordering the children of a parent. Existing blocks start
+-- without a rank and sort before ranked ones, by id.
+
+ALTER TABLE blocks ADD COLUMN position VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS block_position ON blocks (parent, type, position);
@@ -0,0 +1,2 @@
+-- SQLite has no full-text indexes; the sql search backend scores blocks by
+-- the query terms they contain there. Kept so versions line up across dialects.
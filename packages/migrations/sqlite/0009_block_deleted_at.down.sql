@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS block_deleted;
+ALTER TABLE blocks DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+-- When a block went to the trash, so later writes do not reset its retention.
+-- Blocks already in the trash count from their last change.
+
+ALTER TABLE blocks ADD COLUMN deleted_at TEXT NULL;
+CREATE INDEX IF NOT EXISTS block_deleted ON blocks (status, deleted_at);
+
+UPDATE blocks SET deleted_at = modified_at WHERE status <> 1;
@@ -0,0 +1,2 @@
+-- Only Postgres needs a function to parse JSON without failing the query.
+-- Kept so versions line up across dialects.
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BlockDocument returns the patchable view of a block: its title, content and
+// metas. JSON, bool and number metas are decoded so patches can address their
+// contents; privilege metas are left out and secrets are masked.
+func (dm *DatabaseManager) BlockDocument(ctx context.Context, block *Block) (map[string]interface{}, error) {
+	metas, err := dm.GetMetas(ctx, block.ID, block.Type, nil)
+	if err != nil {
+		return nil, err
+	}
+	def, _ := GetBlockTypeDefinition(block.Type)
+
+	docMetas := make(map[string]interface{}, len(metas))
+	for key, value := range metas {
+		if strings.HasPrefix(key, "privilege_") {
+			continue
+		}
+		docMetas[key] = decodeMetaValue(def, key, value)
+	}
+
+	return map[string]interface{}{
+		"title":   block.Title,
+		"content": block.Content,
+		"metas":   docMetas,
+	}, nil
+}
+
+func decodeMetaValue(def BlockTypeDefinition, key, value string) interface{} {
+	field, ok := def.GetMetaField(key)
+	if !ok || field.Secret {
+		return value
+	}
+	switch field.Type {
+	case MetaJSON, MetaBool, MetaNumber:
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+			return decoded
+		}
+	}
+	return value
+}
+
+// PatchBlock replaces a block's title, content and metas with a patched
+// document produced from BlockDocument. Only metas that changed are written,
+// metas missing from the document are deleted, and everything is applied in
+// one transaction. A non-zero version makes the write conditional.
+func (dm *DatabaseManager) PatchBlock(ctx context.Context, userID int64, block *Block, patched interface{}, version int64) error {
+	def, ok := GetBlockTypeDefinition(block.Type)
+	if !ok {
+		return NewValidationError("type", fmt.Sprintf("unknown block type %q", block.Type))
+	}
+	original, err := dm.BlockDocument(ctx, block)
+	if err != nil {
+		return err
+	}
+
+	doc, ok := patched.(map[string]interface{})
+	if !ok {
+		return NewValidationError("document", "must be an object")
+	}
+	verr := &ValidationError{}
+	for key := range doc {
+		if key != "title" && key != "content" && key != "metas" {
+			verr.Add(key, "cannot be patched")
+		}
+	}
+	title, titleOK := doc["title"].(string)
+	if !titleOK {
+		verr.Add("title", "must be a string")
+	}
+	content, contentOK := doc["content"].(string)
+	if !contentOK {
+		verr.Add("content", "must be a string")
+	}
+	metas, metasOK := doc["metas"].(map[string]interface{})
+	if doc["metas"] == nil {
+		metas, metasOK = map[string]interface{}{}, true
+	}
+	if !metasOK {
+		verr.Add("metas", "must be an object")
+	}
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+
+	for field, message := range def.ValidateBlock(title, content, block.Slug).Fields {
+		verr.Add(field, message)
+	}
+
+	before := original["metas"].(map[string]interface{})
+	changed := map[string]interface{}{}
+	for key, value := range metas {
+		if strings.TrimSpace(key) == "" || strings.HasPrefix(key, "privilege_") {
+			verr.Add("metas."+key, "is reserved")
+			continue
+		}
+		if old, exists := before[key]; exists && jsonEqual(old, value) {
+			continue
+		}
+		if value == nil {
+			value = ""
+		}
+		if err := def.ValidateMeta(key, value); err != nil {
+			verr.Add("metas."+key, err.Error())
+		}
+		changed[key] = value
+	}
+	var removed []string
+	for key := range before {
+		if _, ok := metas[key]; ok {
+			continue
+		}
+		if field, declared := def.GetMetaField(key); declared && field.Required {
+			verr.Add("metas."+key, "is required")
+		}
+		removed = append(removed, key)
+	}
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		if err := tx.lockRevisions(ctx, block.ID); err != nil {
+			return err
+		}
+
+		query := "UPDATE blocks SET title = ?, content = ?, modified_at = ?, version = version + 1 WHERE id = ? AND status = 1"
+		args := []interface{}{title, content, time.Now().Format("2006-01-02 15:04:05"), block.ID}
+		if version > 0 {
+			query += " AND version = ?"
+			args = append(args, version)
+		}
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 0 && version > 0 {
+			return ErrVersionConflict
+		}
+
+		for key, value := range changed {
+			if err := tx.addMeta(ctx, block.Type, block.ID, key, value); err != nil {
+				return err
+			}
+		}
+		if err := deleteMetas(ctx, tx, block.Type, block.ID, removed); err != nil {
+			return err
+		}
+		if err := tx.recordRevision(ctx, block.ID, userID); err != nil {
+			return err
+		}
+		return tx.publishBlock(ctx, EventBlockUpdated, block.ID)
+	})
+}
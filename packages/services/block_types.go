@@ -0,0 +1,400 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Meta value types understood by block type schemas
+const (
+	MetaString = "string"
+	MetaNumber = "number"
+	MetaBool   = "bool"
+	MetaDate   = "date"
+	MetaEnum   = "enum"
+	MetaJSON   = "json"
+)
+
+// maxSlugLength matches the width of the blocks.slug column
+const maxSlugLength = 120
+
+// TextRule constrains a block's title or content
+type TextRule struct {
+	Required  bool `json:"required,omitempty" yaml:"required"`
+	MaxLength int  `json:"max_length,omitempty" yaml:"max_length"`
+}
+
+// SlugRule constrains the slugs a block type accepts
+type SlugRule struct {
+	Pattern   string `json:"pattern,omitempty" yaml:"pattern"`
+	MaxLength int    `json:"max_length,omitempty" yaml:"max_length"`
+
+	re *regexp.Regexp
+}
+
+// MetaField declares one meta a block type understands
+type MetaField struct {
+	Name       string   `json:"name" yaml:"name"`
+	Type       string   `json:"type" yaml:"type"`
+	Required   bool     `json:"required,omitempty" yaml:"required"`
+	Options    []string `json:"options,omitempty" yaml:"options"`
+	MaxLength  int      `json:"max_length,omitempty" yaml:"max_length"`
+	Secret     bool     `json:"secret,omitempty" yaml:"secret"`         // encrypted at rest and masked by read APIs
+	Searchable bool     `json:"searchable,omitempty" yaml:"searchable"` // included in full-text search
+}
+
+// BlockTypeDefinition describes a block type: where it may live, what its
+// title, content and slug look like and which metas it carries.
+type BlockTypeDefinition struct {
+	Name        string      `json:"name" yaml:"name"`
+	Parents     []string    `json:"parents" yaml:"parents"` // allowed parent types, empty for top-level blocks
+	Title       TextRule    `json:"title" yaml:"title"`
+	Content     TextRule    `json:"content" yaml:"content"`
+	Slug        SlugRule    `json:"slug" yaml:"slug"`
+	Metas       []MetaField `json:"metas,omitempty" yaml:"metas"`
+	StrictMetas bool        `json:"strict_metas,omitempty" yaml:"strict_metas"` // reject metas that are not declared
+	Revisions   int         `json:"revisions,omitempty" yaml:"revisions"`       // revisions kept per block, 0 for the default, negative to disable
+
+	// Relations maps link relation names to the block types they may point
+	// at. An empty target list accepts any type; no relations accepts any link.
+	Relations map[string][]string `json:"relations,omitempty" yaml:"relations"`
+}
+
+// IsTopLevel reports whether blocks of this type live directly under a system
+func (d BlockTypeDefinition) IsTopLevel() bool {
+	return len(d.Parents) == 0
+}
+
+// AllowsParent reports whether blocks of this type may be created under parentType
+func (d BlockTypeDefinition) AllowsParent(parentType string) bool {
+	for _, p := range d.Parents {
+		if p == parentType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMetaField looks up a declared meta by name
+func (d BlockTypeDefinition) GetMetaField(name string) (MetaField, bool) {
+	for _, f := range d.Metas {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return MetaField{}, false
+}
+
+// compile checks the definition and prepares its slug pattern
+func (d *BlockTypeDefinition) compile() error {
+	if strings.TrimSpace(d.Name) == "" {
+		return fmt.Errorf("block type name is required")
+	}
+	if d.Slug.Pattern != "" {
+		re, err := regexp.Compile(d.Slug.Pattern)
+		if err != nil {
+			return fmt.Errorf("block type %s: invalid slug pattern: %v", d.Name, err)
+		}
+		d.Slug.re = re
+	}
+
+	for relation := range d.Relations {
+		if !relationPattern.MatchString(relation) {
+			return fmt.Errorf("block type %s: invalid relation name %q", d.Name, relation)
+		}
+	}
+
+	seen := make(map[string]bool, len(d.Metas))
+	for _, f := range d.Metas {
+		if f.Name == "" {
+			return fmt.Errorf("block type %s: meta name is required", d.Name)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("block type %s: meta %s declared twice", d.Name, f.Name)
+		}
+		seen[f.Name] = true
+
+		switch f.Type {
+		case MetaString, MetaNumber, MetaBool, MetaDate, MetaJSON:
+		case MetaEnum:
+			if len(f.Options) == 0 {
+				return fmt.Errorf("block type %s: enum meta %s needs options", d.Name, f.Name)
+			}
+		default:
+			return fmt.Errorf("block type %s: meta %s has unknown type %q", d.Name, f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+// ValidateBlock checks title, content and slug against the definition
+func (d BlockTypeDefinition) ValidateBlock(title, content, slug string) *ValidationError {
+	verr := &ValidationError{}
+
+	if d.Title.Required && strings.TrimSpace(title) == "" {
+		verr.Add("title", "is required")
+	} else if d.Title.MaxLength > 0 && utf8Len(title) > d.Title.MaxLength {
+		verr.Add("title", fmt.Sprintf("must be at most %d characters", d.Title.MaxLength))
+	}
+
+	if d.Content.Required && strings.TrimSpace(content) == "" {
+		verr.Add("content", "is required")
+	} else if d.Content.MaxLength > 0 && utf8Len(content) > d.Content.MaxLength {
+		verr.Add("content", fmt.Sprintf("must be at most %d characters", d.Content.MaxLength))
+	}
+
+	maxLen := maxSlugLength
+	if d.Slug.MaxLength > 0 && d.Slug.MaxLength < maxLen {
+		maxLen = d.Slug.MaxLength
+	}
+	if len(slug) > maxLen {
+		verr.Add("slug", fmt.Sprintf("must be at most %d characters", maxLen))
+	} else if d.Slug.re != nil && !d.Slug.re.MatchString(slug) {
+		verr.Add("slug", "must match "+d.Slug.Pattern)
+	}
+
+	return verr
+}
+
+// ValidateMetas checks a set of metas. When creating, every required meta must
+// be present.
+func (d BlockTypeDefinition) ValidateMetas(metas map[string]interface{}, creating bool) *ValidationError {
+	verr := &ValidationError{}
+	for key, value := range metas {
+		if err := d.ValidateMeta(key, value); err != nil {
+			verr.Add("metas."+key, err.Error())
+		}
+	}
+	if creating {
+		for _, f := range d.Metas {
+			if _, ok := metas[f.Name]; f.Required && !ok {
+				verr.Add("metas."+f.Name, "is required")
+			}
+		}
+	}
+	return verr
+}
+
+// ValidateMeta checks a single meta value against the definition
+func (d BlockTypeDefinition) ValidateMeta(key string, value interface{}) error {
+	if strings.HasPrefix(key, "privilege_") {
+		return nil
+	}
+
+	f, ok := d.GetMetaField(key)
+	if !ok {
+		if d.StrictMetas {
+			return fmt.Errorf("is not a known meta for %s", d.Name)
+		}
+		return nil
+	}
+
+	if value == nil || value == "" {
+		if f.Required {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+	return f.Validate(value)
+}
+
+// Validate checks a value against the declared meta type
+func (f MetaField) Validate(value interface{}) error {
+	switch f.Type {
+	case MetaString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if f.MaxLength > 0 && utf8Len(s) > f.MaxLength {
+			return fmt.Errorf("must be at most %d characters", f.MaxLength)
+		}
+	case MetaNumber:
+		switch v := value.(type) {
+		case float64, float32, int, int64:
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("must be a number")
+			}
+		default:
+			return fmt.Errorf("must be a number")
+		}
+	case MetaBool:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if v != "true" && v != "false" {
+				return fmt.Errorf("must be true or false")
+			}
+		default:
+			return fmt.Errorf("must be true or false")
+		}
+	case MetaDate:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a date")
+		}
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				return fmt.Errorf("must be a date (YYYY-MM-DD or RFC 3339)")
+			}
+		}
+	case MetaEnum:
+		s, _ := value.(string)
+		for _, option := range f.Options {
+			if option == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(f.Options, ", "))
+	case MetaJSON:
+		if s, ok := value.(string); ok && !json.Valid([]byte(s)) {
+			return fmt.Errorf("must be valid JSON")
+		}
+	}
+	return nil
+}
+
+func utf8Len(s string) int {
+	return len([]rune(s))
+}
+
+var (
+	blockTypesMu sync.RWMutex
+	blockTypes   = map[string]BlockTypeDefinition{}
+)
+
+func init() {
+	defaults := []BlockTypeDefinition{
+		{
+			Name:  "workspace",
+			Title: TextRule{Required: true, MaxLength: 255},
+			Metas: []MetaField{
+				{Name: "description", Type: MetaString, Searchable: true},
+				{Name: "prompt", Type: MetaString},
+				{Name: "collect_information", Type: MetaBool},
+				{Name: "questionnaire", Type: MetaJSON},
+				{Name: TemplateMetaKey, Type: MetaBool},
+				{Name: RetrievalWeightsMetaKey, Type: MetaJSON},
+				{Name: "stripe_secret_key", Type: MetaString, Secret: true},
+			},
+		},
+		{
+			Name:    "thread",
+			Parents: []string{"workspace"},
+			Title:   TextRule{MaxLength: 255},
+			Metas: []MetaField{
+				{Name: "collected_information", Type: MetaJSON},
+			},
+			Relations: map[string][]string{
+				"uses": {"knowledge"},
+			},
+		},
+		{
+			Name:    "knowledge",
+			Parents: []string{"workspace", "thread"},
+			Title:   TextRule{MaxLength: 255},
+		},
+		{
+			Name:    "chunk",
+			Parents: []string{"knowledge"},
+			Content: TextRule{Required: true},
+			Metas: []MetaField{
+				{Name: "embedding", Type: MetaJSON},
+			},
+		},
+		{
+			Name:    "message",
+			Parents: []string{"thread"},
+			Metas: []MetaField{
+				{Name: "role", Type: MetaEnum, Options: []string{"user", "assistant", "system"}},
+			},
+			Relations: map[string][]string{
+				"cites": {"chunk", "knowledge"},
+			},
+		},
+		{
+			Name:    "entry",
+			Parents: []string{"workspace", "thread", "knowledge"},
+			Title:   TextRule{MaxLength: 255},
+		},
+	}
+
+	for _, def := range defaults {
+		if err := RegisterBlockType(def); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterBlockType adds or replaces a block type definition
+func RegisterBlockType(def BlockTypeDefinition) error {
+	if err := def.compile(); err != nil {
+		return err
+	}
+
+	blockTypesMu.Lock()
+	defer blockTypesMu.Unlock()
+	blockTypes[def.Name] = def
+	return nil
+}
+
+// LoadBlockTypes registers block type definitions from a YAML or JSON file of
+// the form {"types": [...]}. Definitions replace built-in types of the same name.
+func LoadBlockTypes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		Types []BlockTypeDefinition `json:"types" yaml:"types"`
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	default:
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	for _, def := range file.Types {
+		if err := RegisterBlockType(def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBlockTypeDefinition looks up a registered block type
+func GetBlockTypeDefinition(name string) (BlockTypeDefinition, bool) {
+	blockTypesMu.RLock()
+	defer blockTypesMu.RUnlock()
+	def, ok := blockTypes[name]
+	return def, ok
+}
+
+// BlockTypeDefinitions lists all registered block types sorted by name
+func BlockTypeDefinitions() []BlockTypeDefinition {
+	blockTypesMu.RLock()
+	defer blockTypesMu.RUnlock()
+
+	defs := make([]BlockTypeDefinition, 0, len(blockTypes))
+	for _, def := range blockTypes {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
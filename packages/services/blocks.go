@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const blockColumns = "id, type, title, content, author, slug, parent, created_at, modified_at, position, version"
+
+// qualifiedBlockColumns selects the same columns from blocks aliased as b
+const qualifiedBlockColumns = "b.id, b.type, b.title, b.content, b.author, b.slug, b.parent, b.created_at, b.modified_at, b.position, b.version"
+
+// maxBlockDepth bounds parent walks so corrupted data can't loop forever
+const maxBlockDepth = 64
+
+// ErrVersionConflict is returned when a block changed since the version the
+// caller last read
+var ErrVersionConflict = errors.New("block was modified since it was last read")
+
+func scanBlock(row interface{ Scan(...interface{}) error }) (*Block, error) {
+	var b Block
+	err := row.Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt, &b.Position, &b.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// BlockToMap converts a block into the map shape returned by the API
+func BlockToMap(b *Block) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          b.ID,
+		"type":        b.Type,
+		"title":       b.Title,
+		"content":     b.Content,
+		"author":      int64(b.Author),
+		"slug":        b.Slug,
+		"parent":      b.Parent,
+		"created_at":  FormatTimeToISO(b.CreatedAt),
+		"modified_at": FormatTimeToISO(b.ModifiedAt),
+		"position":    b.Position,
+		"version":     b.Version,
+	}
+}
+
+// bumpVersion marks a block as changed for optimistic concurrency checks
+func bumpVersion(ctx context.Context, q queryer, id int64) error {
+	_, err := q.ExecContext(ctx, "UPDATE blocks SET version = version + 1 WHERE id = ?", id)
+	return err
+}
+
+// FindBlock fetches an active block by id or slug without any access
+// filtering; callers are responsible for permission checks.
+func (dm *DatabaseManager) FindBlock(ctx context.Context, blockType string, id int64, slug string) (*Block, error) {
+	return dm.stores.Blocks.FindBlock(ctx, blockType, id, slug)
+}
+
+// GetChildBlocks lists active blocks of a type directly under parent, filtered,
+// ordered, paginated and with metas selected by opts
+func (dm *DatabaseManager) GetChildBlocks(ctx context.Context, blockType string, parent int64, page int, opts ListOptions) (*BlockPage, error) {
+	if parent <= 0 {
+		return nil, errors.New("parent is required")
+	}
+	return dm.listBlocks(ctx, blockType,
+		"blocks",
+		"FROM blocks WHERE type = ? AND parent = ? AND status = 1",
+		[]interface{}{blockType, parent},
+		page, opts,
+	)
+}
+
+// GetRootBlock returns the top-level block (usually a workspace) that owns a
+// block, or nil when the block or any of its ancestors is not active
+func (dm *DatabaseManager) GetRootBlock(ctx context.Context, id int64) (*Block, error) {
+	refs, err := ancestorRefs(ctx, dm.q(), id)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if ref.Status != StatusActive {
+			return nil, nil
+		}
+	}
+
+	rootID := id
+	if len(refs) > 0 {
+		rootID = refs[len(refs)-1].ID
+	}
+	return dm.FindBlock(ctx, "", rootID, "")
+}
+
+// validateBlock checks a block about to be written by AddBlock against its
+// registered type
+func (dm *DatabaseManager) validateBlock(ctx context.Context, blockType string, block map[string]interface{}, slug string, parent int64, metas map[string]interface{}, creating bool) error {
+	def, ok := GetBlockTypeDefinition(blockType)
+	if !ok {
+		return NewValidationError("type", fmt.Sprintf("unknown block type %q", blockType))
+	}
+
+	title, _ := block["title"].(string)
+	content, _ := block["content"].(string)
+	verr := def.ValidateBlock(title, content, slug)
+
+	if creating {
+		if def.IsTopLevel() {
+			if parent > 0 {
+				verr.Add("parent", fmt.Sprintf("%s must be top-level", blockType))
+			}
+		} else {
+			var parentType string
+			err := dm.q().QueryRowContext(ctx, "SELECT type FROM blocks WHERE id = ? AND status = 1", parent).Scan(&parentType)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == sql.ErrNoRows {
+				verr.Add("parent", "not found")
+			} else if !def.AllowsParent(parentType) {
+				verr.Add("parent", fmt.Sprintf("%s cannot be placed under %s", blockType, parentType))
+			}
+		}
+	}
+
+	for field, message := range def.ValidateMetas(metas, creating).Fields {
+		verr.Add(field, message)
+	}
+	return verr.OrNil()
+}
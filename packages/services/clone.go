@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TemplateMetaKey marks a workspace as a template that can be cloned from the
+// template gallery
+const TemplateMetaKey = "is_template"
+
+// CloneOptions controls what CloneBlock copies
+type CloneOptions struct {
+	Parent       int64    // new parent for the copy, 0 for top-level blocks
+	Title        string   // title for the copy, empty to keep the original
+	ExcludeTypes []string // block types skipped together with their subtrees
+}
+
+// CloneBlock copies an active block, its active subtree and their metas under
+// a new parent. Every copy gets a fresh slug, and links between copied blocks
+// are pointed at the copies. Privilege metas are not copied, and the template
+// marker is dropped from the copied root.
+func (dm *DatabaseManager) CloneBlock(ctx context.Context, userID, sourceID int64, opts CloneOptions) (*Block, error) {
+	source, err := dm.FindBlock(ctx, "", sourceID, "")
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	def, ok := GetBlockTypeDefinition(source.Type)
+	if !ok {
+		return nil, NewValidationError("type", fmt.Sprintf("unknown block type %q", source.Type))
+	}
+	if def.IsTopLevel() {
+		opts.Parent = 0
+	} else {
+		var parentType string
+		err := dm.q().QueryRowContext(ctx, "SELECT type FROM blocks WHERE id = ? AND status = 1", opts.Parent).Scan(&parentType)
+		if err == sql.ErrNoRows {
+			return nil, NewValidationError("parent", "not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !def.AllowsParent(parentType) {
+			return nil, NewValidationError("parent", fmt.Sprintf("%s cannot be placed under %s", source.Type, parentType))
+		}
+	}
+	if opts.Title != "" {
+		if verr := def.ValidateBlock(opts.Title, source.Content, source.Slug).OrNil(); verr != nil {
+			return nil, verr
+		}
+	}
+
+	copies := map[int64]int64{}
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		blocks, err := cloneSources(ctx, tx, source)
+		if err != nil {
+			return err
+		}
+
+		excluded := make(map[string]bool, len(opts.ExcludeTypes))
+		for _, t := range opts.ExcludeTypes {
+			excluded[t] = true
+		}
+
+		now := time.Now().Format("2006-01-02 15:04:05")
+		var order []int64
+		for i, b := range blocks {
+			parent := opts.Parent
+			position := b.Position
+			title := b.Title
+			skipMeta := ""
+
+			if i == 0 {
+				if position, err = lastPosition(ctx, tx, parent, b.Type); err != nil {
+					return err
+				}
+				if opts.Title != "" {
+					title = opts.Title
+				}
+				skipMeta = TemplateMetaKey
+			} else {
+				// Blocks arrive parents first, so a missing parent was excluded
+				newParent, ok := copies[*b.Parent]
+				if !ok || excluded[b.Type] {
+					continue
+				}
+				parent = newParent
+			}
+
+			newID, err := tx.InsertIDContext(ctx,
+				"INSERT INTO blocks (type, title, content, author, slug, parent, created_at, modified_at, status, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)",
+				b.Type, title, b.Content, userID, NewSlug(15), parent, now, now, position,
+			)
+			if err != nil {
+				return err
+			}
+			copies[b.ID] = newID
+			order = append(order, b.ID)
+
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO metas (parent, parent_id, meta_key, meta_value, status) SELECT parent, ?, meta_key, meta_value, 1 FROM metas WHERE parent = ? AND parent_id = ? AND status = 1 AND SUBSTR(meta_key, 1, 10) <> 'privilege_' AND meta_key <> ?",
+				newID, b.Type, b.ID, skipMeta,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, id := range order {
+			if err := cloneLinks(ctx, tx, id, copies, userID, now); err != nil {
+				return err
+			}
+		}
+		for _, id := range order {
+			if err := tx.publishBlock(ctx, EventBlockCreated, copies[id]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dm.FindBlock(ctx, "", copies[source.ID], "")
+}
+
+// cloneSources loads the block and its active descendants, parents first
+func cloneSources(ctx context.Context, q queryer, source *Block) ([]*Block, error) {
+	cte, args := descendantsCTE(source.ID, maxBlockDepth, []int{StatusActive}, nil)
+	rows, err := q.QueryContext(ctx,
+		cte+"SELECT "+qualifiedBlockColumns+" FROM tree t INNER JOIN blocks b ON b.id = t.id ORDER BY t.depth, b.id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []*Block{source}
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// cloneLinks copies the outgoing links of a cloned block. Links to blocks that
+// were copied too point at the copy; other links keep their target.
+func cloneLinks(ctx context.Context, q queryer, sourceID int64, copies map[int64]int64, userID int64, now string) error {
+	rows, err := q.QueryContext(ctx,
+		"SELECT l.target_id, l.relation, l.position, l.metadata FROM block_links l INNER JOIN blocks b ON b.id = l.target_id WHERE l.source_id = ? AND b.status = 1",
+		sourceID,
+	)
+	if err != nil {
+		return err
+	}
+
+	type link struct {
+		target   int64
+		relation string
+		position int
+		metadata string
+	}
+	var links []link
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.target, &l.relation, &l.position, &l.metadata); err != nil {
+			rows.Close()
+			return err
+		}
+		links = append(links, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		target := l.target
+		if copied, ok := copies[target]; ok {
+			target = copied
+		}
+		_, err := q.ExecContext(ctx,
+			"INSERT INTO block_links (source_id, target_id, relation, position, metadata, author, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			copies[sourceID], target, l.relation, l.position, l.metadata, userID, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTemplates lists the active workspaces marked as templates by users of the
+// current system
+func (dm *DatabaseManager) GetTemplates(ctx context.Context) ([]map[string]interface{}, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT "+blockColumns+" FROM blocks WHERE type = 'workspace' AND status = 1 AND parent = 0"+
+			" AND author IN ( SELECT id FROM users WHERE system_id = ? )"+
+			" AND id IN ( SELECT parent_id FROM metas WHERE parent = 'workspace' AND meta_key = ? AND meta_value = 'true' AND status = 1 )"+
+			" ORDER BY title, id",
+		dm.systemID, TemplateMetaKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []map[string]interface{}{}
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, BlockToMap(b))
+	}
+	return templates, rows.Err()
+}
+
+// IsTemplate reports whether a block is a template of the current system
+func (dm *DatabaseManager) IsTemplate(ctx context.Context, b *Block) (bool, error) {
+	value, err := dm.GetMeta(ctx, b.Type, b.ID, TemplateMetaKey)
+	if err != nil || value != "true" {
+		return false, err
+	}
+
+	author, err := dm.stores.Users.FindUser(ctx, int64(b.Author))
+	if err != nil || author == nil {
+		return false, err
+	}
+	return author.SystemID == dm.systemID, nil
+}
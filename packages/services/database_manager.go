@@ -1,18 +1,18 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/miumoin/agencybot/packages/database"
 )
 
 type BlockType struct {
@@ -30,16 +30,29 @@ type BlockType struct {
 }
 
 type DatabaseManager struct {
-	db        *sql.DB
+	db        *database.DB
+	tx        *database.Tx // set on managers handed out by WithTx
+	events    *[]Event     // events held until the transaction commits
+	stores    Stores
 	domain    string
 	accessKey string
 	userID    int64
 	systemID  int64
 }
 
-func NewDatabaseManager(db *sql.DB, domain, accessKey string) (*DatabaseManager, error) {
+// NewDatabaseManager resolves the system and user of a request against the
+// tables of db
+func NewDatabaseManager(ctx context.Context, db *database.DB, domain, accessKey string) (*DatabaseManager, error) {
+	return NewDatabaseManagerWithStores(ctx, db, NewSQLStores(db), domain, accessKey)
+}
+
+// NewDatabaseManagerWithStores is NewDatabaseManager reading users, systems,
+// metas and single blocks from stores. Lists, trees, search, transactions and
+// writes to blocks still run on db, which is required.
+func NewDatabaseManagerWithStores(ctx context.Context, db *database.DB, stores Stores, domain, accessKey string) (*DatabaseManager, error) {
 	dm := &DatabaseManager{
 		db:        db,
+		stores:    stores,
 		domain:    domain,
 		accessKey: accessKey,
 	}
@@ -47,7 +60,7 @@ func NewDatabaseManager(db *sql.DB, domain, accessKey string) (*DatabaseManager,
 	if domain == "" {
 		dm.systemID = 0
 	} else {
-		systemID, err := dm.getSystemIDByDomain(domain)
+		systemID, err := dm.getSystemIDByDomain(ctx, domain)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get system ID: %v", err)
 		}
@@ -57,7 +70,7 @@ func NewDatabaseManager(db *sql.DB, domain, accessKey string) (*DatabaseManager,
 	if accessKey == "" {
 		dm.userID = 0
 	} else {
-		userID, err := dm.getUserIDByAccessKey(accessKey)
+		userID, err := dm.getUserIDByAccessKey(ctx, accessKey)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get user ID: %v", err)
 		}
@@ -75,140 +88,134 @@ func (dm *DatabaseManager) GetSystemID() int64 {
 	return dm.systemID
 }
 
-func (dm *DatabaseManager) getUserIDByAccessKey(accessKey string) (int, error) {
-	var userID int
-	err := dm.db.QueryRow("SELECT id FROM users WHERE access_key = ?", accessKey).Scan(&userID)
+func (dm *DatabaseManager) getUserIDByAccessKey(ctx context.Context, accessKey string) (int, error) {
+	user, err := dm.stores.Users.FindUserByAccessKey(ctx, accessKey)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, errors.New("user not found")
-		}
 		return 0, err
 	}
-	return userID, nil
-}
-
-func (dm *DatabaseManager) getSystemIDByDomain(domain string) (int64, error) {
-	var systemID int64
-	err := dm.db.QueryRow(
-		"SELECT id FROM systems WHERE (subdomain = ? OR domain = ?) AND status = 1",
-		domain, domain,
-	).Scan(&systemID)
-
-	if err == nil {
-		return systemID, nil
+	if user == nil {
+		return 0, errors.New("user not found")
 	}
+	return int(user.ID), nil
+}
 
-	if err != sql.ErrNoRows {
-		return 0, err
+func (dm *DatabaseManager) getSystemIDByDomain(ctx context.Context, domain string) (int64, error) {
+	systemID, err := dm.stores.Systems.FindSystem(ctx, domain)
+	if err != nil || systemID > 0 {
+		return systemID, err
 	}
+	return dm.stores.Systems.AddSystem(ctx, domain)
+}
 
-	result, err := dm.db.Exec(
-		"INSERT INTO systems (subdomain, domain, status) VALUES (?, ?, 1)",
-		domain, domain,
-	)
+func (dm *DatabaseManager) AddUser(ctx context.Context, email, password string) (int64, error) {
+	user, err := dm.stores.Users.FindUserByEmail(ctx, email)
 	if err != nil {
 		return 0, err
 	}
 
-	newID, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+	if user != nil {
+		return user.ID, errors.New("user already exists")
 	}
 
-	return newID, nil
+	return dm.stores.Users.AddUser(ctx, User{
+		SystemID:  dm.systemID,
+		Email:     email,
+		Password:  password,
+		AccessKey: uuid.New().String(),
+	})
 }
 
-func (dm *DatabaseManager) AddUser(email, password string) (int64, error) {
-	var id int64
-	err := dm.db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id)
-	if err != nil {
-		return 0, err
-	}
-
-	if id > 0 {
-		return id, errors.New("user already exists")
-	}
-
-	accessKey := uuid.New().String()
-	result, err := dm.db.Exec(
-		"INSERT INTO users (email, password, access_key, system_id) VALUES (?, ?, ?, ?)",
-		email, password, accessKey, dm.systemID,
-	)
-	if err != nil {
-		return 0, err
+func (dm *DatabaseManager) GetAccessKey(ctx context.Context, userID int64) ([]string, error) {
+	user, err := dm.stores.Users.FindUser(ctx, userID)
+	if err != nil || user == nil {
+		return nil, err
 	}
+	return []string{user.Email, user.AccessKey}, nil
+}
 
-	userID, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+func (dm *DatabaseManager) AddMeta(ctx context.Context, parent string, parentID int64, metaKey string, metaValue interface{}) error {
+	def, isBlock := GetBlockTypeDefinition(parent)
+	if isBlock {
+		if err := def.ValidateMeta(metaKey, metaValue); err != nil {
+			return NewValidationError("metas."+metaKey, err.Error())
+		}
 	}
 
-	return userID, nil
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		if isBlock {
+			if err := tx.lockRevisions(ctx, parentID); err != nil {
+				return err
+			}
+		}
+		if err := tx.addMeta(ctx, parent, parentID, metaKey, metaValue); err != nil {
+			return err
+		}
+		if isBlock && !strings.HasPrefix(metaKey, "privilege_") {
+			if err := bumpVersion(ctx, tx, parentID); err != nil {
+				return err
+			}
+		}
+		if isBlock {
+			if err := tx.recordRevision(ctx, parentID, tx.userID); err != nil {
+				return err
+			}
+		}
+		tx.publishMetaChanges(ctx, parent, parentID, metaKey)
+		return nil
+	})
 }
 
-func (dm *DatabaseManager) GetAccessKey(userID int64) ([]string, error) {
-	var email, accessKey string
-	err := dm.db.QueryRow(
-		"SELECT email, access_key FROM users WHERE id = ?",
-		userID,
-	).Scan(&email, &accessKey)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
+// addMeta writes a meta without validation or revision tracking
+func (dm *DatabaseManager) addMeta(ctx context.Context, parent string, parentID int64, metaKey string, metaValue interface{}) error {
+	value, write, err := storedMetaValue(parent, parentID, metaKey, metaValue)
+	if err != nil || !write {
+		return err
 	}
-	return []string{email, accessKey}, nil
+	return dm.stores.Metas.SetMeta(ctx, parent, parentID, metaKey, value)
 }
 
-func (dm *DatabaseManager) AddMeta(parent string, parentID int64, metaKey string, metaValue interface{}) error {
-	var value string
+// encodeMetaValue stores strings as-is and everything else as JSON
+func encodeMetaValue(metaValue interface{}) (string, error) {
 	switch v := metaValue.(type) {
 	case string:
-		value = v
+		return v, nil
 	default:
 		b, err := json.Marshal(v)
 		if err != nil {
-			return err
+			return "", err
 		}
-		value = string(b)
+		return string(b), nil
 	}
+}
 
-	var exists bool
-	err := dm.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ?)",
-		parent, parentID, metaKey,
-	).Scan(&exists)
+// storedMetaValue encodes a meta value and seals secrets. Secrets are refused
+// with ErrSecretsNotConfigured when no master keys are loaded. The boolean is
+// false when the stored value must be left unchanged.
+func storedMetaValue(parent string, parentID int64, metaKey string, metaValue interface{}) (string, bool, error) {
+	value, err := encodeMetaValue(metaValue)
 	if err != nil {
-		return err
+		return "", false, err
 	}
-
-	if exists {
-		_, err = dm.db.Exec(
-			"UPDATE metas SET meta_value = ?, status = 1 WHERE parent = ? AND parent_id = ? AND meta_key = ?",
-			value, parent, parentID, metaKey,
-		)
-	} else {
-		_, err = dm.db.Exec(
-			"INSERT INTO metas (parent, parent_id, meta_key, meta_value, status) VALUES (?, ?, ?, ?, 1)",
-			parent, parentID, metaKey, value,
-		)
+	if isSecretMeta(parent, metaKey) && value != "" && !isSealedSecret(value) {
+		// A masked hint sent back by a client leaves the secret unchanged
+		if strings.HasPrefix(value, secretMask) {
+			return "", false, nil
+		}
+		if value, err = sealSecret(parent, parentID, metaKey, value); err != nil {
+			return "", false, err
+		}
 	}
-	return err
+	return value, true, nil
 }
 
-func (dm *DatabaseManager) GetMeta(parent string, parentID int64, key string) (string, error) {
-	var value string
-	err := dm.db.QueryRow(
-		"SELECT meta_value FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ? AND status = 1",
-		parent, parentID, key,
-	).Scan(&value)
+func (dm *DatabaseManager) GetMeta(ctx context.Context, parent string, parentID int64, key string) (string, error) {
+	value, _, err := dm.stores.Metas.GetMeta(ctx, parent, parentID, key)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", nil
-		}
 		return "", err
 	}
+	if isSecretMeta(parent, key) {
+		return maskSecret(value), nil
+	}
 	return value, nil
 }
 
@@ -222,51 +229,113 @@ type Block struct {
 	Parent     *int64 `json:"parent"`
 	CreatedAt  string `json:"created_at"`
 	ModifiedAt string `json:"modified_at"`
+	Position   string `json:"position"`
+	Version    int64  `json:"version"`
 }
 
-func (dm *DatabaseManager) AddBlock(userID int64, block map[string]interface{}, slug string) (map[string]interface{}, error) {
+func (dm *DatabaseManager) AddBlock(ctx context.Context, userID int64, block map[string]interface{}, slug string) (map[string]interface{}, error) {
 	if slug == "" {
 		slug = NewSlug(15)
 	}
 
 	var existingID int
-	err := dm.db.QueryRow("SELECT id FROM blocks WHERE slug = ?", slug).Scan(&existingID)
+	var existingType string
+	err := dm.q().QueryRowContext(ctx, "SELECT id, type FROM blocks WHERE slug = ?", slug).Scan(&existingID, &existingType)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	creating := err == sql.ErrNoRows
+
+	blockType, _ := block["type"].(string)
+	if !creating {
+		blockType = existingType
+	}
+
+	parentPtr := int64(0)
+	switch p := block["parent"].(type) {
+	case int:
+		parentPtr = int64(p)
+	case int64:
+		parentPtr = p
+	}
+
+	metas, _ := block["metas"].(map[string]interface{})
+	if err := dm.validateBlock(ctx, blockType, block, slug, parentPtr, metas, creating); err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
-	if err == nil {
-		_, err = dm.db.Exec(
-			"UPDATE blocks SET title = ?, content = ?, modified_at = ? WHERE slug = ?",
-			block["title"], block["content"], now.Format("2006-01-02 15:04:05"), slug,
-		)
-		if err != nil {
-			return nil, err
-		}
-	} else if err == sql.ErrNoRows {
-		parentPtr := int64(0)
-		if p, ok := block["parent"].(int); ok {
-			parentPtr = int64(p)
+	var b Block
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		if !creating {
+			if err := tx.lockRevisions(ctx, int64(existingID)); err != nil {
+				return err
+			}
+
+			query := "UPDATE blocks SET title = ?, content = ?, modified_at = ?, version = version + 1 WHERE slug = ?"
+			args := []interface{}{block["title"], block["content"], now.Format("2006-01-02 15:04:05"), slug}
+
+			// A caller-supplied version makes the update conditional
+			var expected int64
+			switch v := block["version"].(type) {
+			case int:
+				expected = int64(v)
+			case int64:
+				expected = v
+			}
+			if expected > 0 {
+				query += " AND version = ?"
+				args = append(args, expected)
+			}
+
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			if affected, err := res.RowsAffected(); err == nil && affected == 0 && expected > 0 {
+				return ErrVersionConflict
+			}
+		} else {
+			position, err := tx.nextPosition(ctx, parentPtr, blockType)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO blocks (type, title, content, author, slug, parent, created_at, modified_at, status, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)",
+				block["type"], block["title"], block["content"], userID, slug, parentPtr,
+				now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"), position,
+			)
+			if err != nil {
+				return err
+			}
 		}
 
-		res, err := dm.db.Exec(
-			"INSERT INTO blocks (type, title, content, author, slug, parent, created_at, modified_at, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)",
-			block["type"], block["title"], block["content"], userID, slug, parentPtr,
-			now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"),
-		)
+		err := tx.QueryRowContext(ctx,
+			"SELECT id, type, title, content, author, slug, parent, created_at, modified_at, version FROM blocks WHERE slug = ? AND status = 1",
+			slug,
+		).Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt, &b.Version)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		rows, _ := res.RowsAffected()
-		fmt.Println("Inserted rows:", rows)
-	} else {
-		return nil, err
-	}
+		for key, value := range metas {
+			if err := tx.addMeta(ctx, b.Type, b.ID, key, value); err != nil {
+				return err
+			}
+		}
+		if err := tx.recordRevision(ctx, b.ID, userID); err != nil {
+			return err
+		}
 
-	var b Block
-	err = dm.db.QueryRow(
-		"SELECT id, type, title, content, author, slug, parent, created_at, modified_at FROM blocks WHERE slug = ? AND status = 1",
-		slug,
-	).Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt)
+		written := b
+		event := Event{Type: EventBlockUpdated, Block: &written}
+		if creating {
+			event.Type = EventBlockCreated
+		}
+		tx.publish(ctx, event)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -281,6 +350,7 @@ func (dm *DatabaseManager) AddBlock(userID int64, block map[string]interface{},
 		"parent":      b.Parent,
 		"created_at":  FormatTimeToISO(b.CreatedAt),
 		"modified_at": FormatTimeToISO(b.ModifiedAt),
+		"version":     b.Version,
 	}, nil
 }
 
@@ -294,11 +364,8 @@ func NewSlug(n int) string {
 	return string(b)
 }
 
-func (dm *DatabaseManager) GetBlocks(userID int64, blockType string, page int, entriesPerPage int, parent int64) ([]map[string]interface{}, error) {
-	offset := (page - 1) * entriesPerPage
-
-	query := `
-SELECT id, type, title, content, author, slug, parent, created_at, modified_at
+func (dm *DatabaseManager) GetBlocks(ctx context.Context, userID int64, blockType string, page int, parent int64, opts ListOptions) (*BlockPage, error) {
+	from := `
 FROM blocks
 WHERE ( author = ? OR id IN (
     SELECT parent_id FROM metas
@@ -313,55 +380,50 @@ AND type = ? AND status = 1
 		blockType,
 	}
 	if parent > 0 {
-		query += " AND parent = ?"
+		from += " AND parent = ?"
 		args = append(args, parent)
 	}
 
-	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
-	args = append(args, entriesPerPage, offset)
+	return dm.listBlocks(ctx, blockType, "blocks", from, args, page, opts)
+}
 
-	rows, err := dm.db.Query(query, args...)
+// readableChildren lists the active blocks of a type directly under parent
+// that the user authored or holds a privilege on, newest first
+func (dm *DatabaseManager) readableChildren(ctx context.Context, userID int64, blockType string, parent int64) ([]map[string]interface{}, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT "+blockColumns+" FROM blocks WHERE parent = ? AND type = ? AND status = 1 AND ( author = ? OR id IN ( SELECT parent_id FROM metas WHERE parent = ? AND meta_key = ? ) ) ORDER BY id DESC",
+		parent, blockType, userID, blockType, "privilege_"+strconv.FormatInt(userID, 10),
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var blocks []map[string]interface{}
+	children := []map[string]interface{}{}
 	for rows.Next() {
-		var b Block
-		err := rows.Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt)
+		b, err := scanBlock(rows)
 		if err != nil {
-			log.Println(err)
-			continue
+			return nil, err
 		}
-
-		blocks = append(blocks, map[string]interface{}{
-			"id":          int64(b.ID),
-			"type":        b.Type,
-			"title":       b.Title,
-			"content":     b.Content,
-			"author":      int64(b.Author),
-			"slug":        b.Slug,
-			"parent":      b.Parent,
-			"created_at":  FormatTimeToISO(b.CreatedAt),
-			"modified_at": FormatTimeToISO(b.ModifiedAt),
-			"metas":       map[string]string{},
-		})
-	}
-
-	return blocks, nil
+		children = append(children, BlockToMap(b))
+	}
+	return children, rows.Err()
 }
 
+// FormatTimeToISO converts a stored timestamp to RFC 3339. MySQL returns
+// "2006-01-02 15:04:05" strings, PostgreSQL returns RFC 3339 already.
 func FormatTimeToISO(mysqlTime string) string {
 	t, err := time.Parse("2006-01-02 15:04:05", mysqlTime)
 	if err != nil {
-		return ""
+		if t, err = time.Parse(time.RFC3339Nano, mysqlTime); err != nil {
+			return ""
+		}
 	}
 	return t.UTC().Format(time.RFC3339)
 }
 
-func (dm *DatabaseManager) GetBlock(userID int64, blockType string, id int64, slug string, parent int64) (map[string]interface{}, error) {
-	query := "SELECT id, type, title, content, author, slug, parent, created_at, modified_at FROM blocks WHERE status > 0 AND ( author = ? OR id IN ( SELECT parent_id FROM metas WHERE parent = ? AND meta_key = ? ) )"
+func (dm *DatabaseManager) GetBlock(ctx context.Context, userID int64, blockType string, id int64, slug string, parent int64) (map[string]interface{}, error) {
+	query := "SELECT id, type, title, content, author, slug, parent, created_at, modified_at FROM blocks WHERE status = 1 AND ( author = ? OR id IN ( SELECT parent_id FROM metas WHERE parent = ? AND meta_key = ? ) )"
 	args := []interface{}{userID, blockType, "privilege_" + strconv.FormatInt(userID, 10)}
 
 	if blockType != "" {
@@ -382,7 +444,7 @@ func (dm *DatabaseManager) GetBlock(userID int64, blockType string, id int64, sl
 	}
 
 	var b BlockType
-	err := dm.db.QueryRow(query, args...).Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt)
+	err := dm.q().QueryRowContext(ctx, query, args...).Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -402,12 +464,12 @@ func (dm *DatabaseManager) GetBlock(userID int64, blockType string, id int64, sl
 		"modified_at": FormatTimeToISO(b.ModifiedAt),
 	}
 
-	children, err := dm.GetBlocks(userID, "entry", 1, 999999, b.ID)
+	children, err := dm.readableChildren(ctx, userID, "entry", b.ID)
 	if err == nil && children != nil {
 		result["children"] = children
 	}
 
-	metas, metaErr := dm.GetMetas(b.ID, b.Type, []string{})
+	metas, metaErr := dm.GetMetas(ctx, b.ID, b.Type, []string{})
 	if metaErr == nil && metas != nil {
 		result["metas"] = metas
 	}
@@ -415,32 +477,31 @@ func (dm *DatabaseManager) GetBlock(userID int64, blockType string, id int64, sl
 	return result, nil
 }
 
-func (dm *DatabaseManager) GetMetas(id int64, parent string, metaKeys []string) (map[string]string, error) {
-	var query string
-	if len(metaKeys) > 0 {
-		query = "SELECT meta_key, meta_value FROM metas WHERE parent = ? AND parent_id = ? AND meta_key IN (?" + strings.Repeat(",?", len(metaKeys)-1) + ")"
-	} else {
-		query = "SELECT meta_key, meta_value FROM metas WHERE parent = ? AND parent_id = ?"
-	}
-	rows, err := dm.db.Query(query, parent, id)
+// GetMetas returns the active metas of a parent, optionally only those with
+// the given keys
+func (dm *DatabaseManager) GetMetas(ctx context.Context, id int64, parent string, metaKeys []string) (map[string]string, error) {
+	metas, err := dm.stores.Metas.GetMetas(ctx, parent, id, metaKeys)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	metas := make(map[string]string)
-	for rows.Next() {
-		var key, value string
-		if err := rows.Scan(&key, &value); err != nil {
-			return nil, err
-		}
-		metas[key] = value
-	}
-
+	maskSecretMetas(parent, metas)
 	return metas, nil
 }
 
-func (dm *DatabaseManager) DeleteBlock(id int64) error {
-	_, err := dm.db.Exec("UPDATE blocks SET status = 0 WHERE id = ?", id)
-	return err
+// DeleteBlock moves a block to the trash together with its descendants and
+// their metas
+func (dm *DatabaseManager) DeleteBlock(ctx context.Context, id int64) error {
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		block, err := tx.FindBlock(ctx, "", id, "")
+		if err != nil {
+			return err
+		}
+		if err := trashBlock(ctx, tx, id); err != nil {
+			return err
+		}
+		if block != nil {
+			tx.publish(ctx, Event{Type: EventBlockDeleted, Block: block})
+		}
+		return nil
+	})
 }
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetBlockListsOnlyReadableChildren(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace := addWorkspace(t, dm, "Shop")
+
+	entries := map[string]int64{}
+	for _, e := range []struct {
+		title  string
+		author int64
+	}{{"Own", 1}, {"Shared", 2}, {"Private", 2}} {
+		entry, err := dm.AddBlock(ctx, e.author, map[string]interface{}{"type": "entry", "title": e.title, "content": "", "parent": workspace}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[e.title] = entry["id"].(int64)
+	}
+	if err := dm.AddMeta(ctx, "entry", entries["Shared"], "privilege_1", []string{"read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := dm.GetBlock(ctx, 1, "workspace", workspace, "", 0)
+	if err != nil || block == nil {
+		t.Fatalf("GetBlock = %v, %v", block, err)
+	}
+	children, _ := block["children"].([]map[string]interface{})
+	var ids []int64
+	for _, child := range children {
+		ids = append(ids, child["id"].(int64))
+	}
+	if want := []int64{entries["Shared"], entries["Own"]}; !sameIDs(ids, want) {
+		t.Errorf("children = %v, want %v without the private entry", ids, want)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Embedder turns text into an embedding vector
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+var (
+	embedderMu sync.RWMutex
+	embedder   Embedder
+)
+
+// SetEmbedder configures the embedder used for query embeddings
+func SetEmbedder(e Embedder) {
+	embedderMu.Lock()
+	defer embedderMu.Unlock()
+	embedder = e
+}
+
+func currentEmbedder() Embedder {
+	embedderMu.RLock()
+	defer embedderMu.RUnlock()
+	return embedder
+}
+
+// HTTPEmbedder calls an OpenAI compatible /embeddings endpoint
+type HTTPEmbedder struct {
+	URL    string
+	APIKey string
+	Model  string
+	Client *http.Client
+}
+
+// NewHTTPEmbedder returns an embedder for url using model
+func NewHTTPEmbedder(url, apiKey, model string) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		URL:    url,
+		APIKey: apiKey,
+		Model:  model,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Embed requests the embedding of one text
+func (e *HTTPEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.Model, "input": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("embedding request failed, status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response has no data")
+	}
+	return result.Data[0].Embedding, nil
+}
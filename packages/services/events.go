@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventType names a change published on the event bus
+type EventType string
+
+const (
+	EventBlockCreated EventType = "block.created"
+	EventBlockUpdated EventType = "block.updated"
+	EventBlockDeleted EventType = "block.deleted"
+	EventMetaChanged  EventType = "meta.changed"
+)
+
+// Event describes a committed change. Block events carry the block; metas
+// written together with a block are part of its block event. Trashing a block
+// publishes block.deleted and restoring it block.updated, with no events for
+// the descendants that go and come back with it. meta.changed names one meta,
+// which is gone when it was deleted.
+type Event struct {
+	Type     EventType
+	SystemID int64
+	UserID   int64
+	Time     time.Time
+
+	// Block is the block as written, or as it was before it was deleted
+	Block *Block
+
+	Parent   string
+	ParentID int64
+	Key      string
+}
+
+// EventHandler reacts to an event. Handlers cannot undo the change, which is
+// already committed.
+type EventHandler func(ctx context.Context, e Event)
+
+// EventBus delivers events to the handlers subscribed to their type
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[EventType][]subscriber
+	pending     sync.WaitGroup
+}
+
+type subscriber struct {
+	handler EventHandler
+	async   bool
+}
+
+// NewEventBus returns a bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[EventType][]subscriber{}}
+}
+
+// Subscribe runs h for every event of type t before Publish returns, in the
+// order handlers were subscribed
+func (b *EventBus) Subscribe(t EventType, h EventHandler) {
+	b.subscribe(t, subscriber{handler: h})
+}
+
+// SubscribeAsync runs h for every event of type t in its own goroutine. The
+// context h gets keeps the values of the publisher's but is not cancelled
+// with it.
+func (b *EventBus) SubscribeAsync(t EventType, h EventHandler) {
+	b.subscribe(t, subscriber{handler: h, async: true})
+}
+
+func (b *EventBus) subscribe(t EventType, s subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[t] = append(b.subscribers[t], s)
+}
+
+// subscribed reports whether any handler listens to events of type t
+func (b *EventBus) subscribed(t EventType) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers[t]) > 0
+}
+
+// Publish delivers e to the subscribers of its type. A panicking handler is
+// logged and does not stop the others.
+func (b *EventBus) Publish(ctx context.Context, e Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers[e.Type]
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		if !s.async {
+			runHandler(ctx, s.handler, e)
+			continue
+		}
+		b.pending.Add(1)
+		go func(h EventHandler) {
+			defer b.pending.Done()
+			runHandler(context.WithoutCancel(ctx), h, e)
+		}(s.handler)
+	}
+}
+
+// Wait blocks until the asynchronous handlers started so far have returned
+func (b *EventBus) Wait() {
+	b.pending.Wait()
+}
+
+func runHandler(ctx context.Context, h EventHandler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s handler panicked: %v", e.Type, r)
+		}
+	}()
+	h(ctx, e)
+}
+
+var (
+	eventBusMu sync.RWMutex
+	eventBus   *EventBus
+)
+
+// SetEventBus configures the bus DatabaseManager writes publish to. Without
+// one no events are published.
+func SetEventBus(b *EventBus) {
+	eventBusMu.Lock()
+	defer eventBusMu.Unlock()
+	eventBus = b
+}
+
+func currentEventBus() *EventBus {
+	eventBusMu.RLock()
+	defer eventBusMu.RUnlock()
+	return eventBus
+}
+
+// publish stamps e with the manager's system and user and publishes it, or
+// holds it until the transaction the manager runs in commits
+func (dm *DatabaseManager) publish(ctx context.Context, e Event) {
+	e.SystemID = dm.systemID
+	e.UserID = dm.userID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if dm.events != nil {
+		*dm.events = append(*dm.events, e)
+		return
+	}
+	if bus := currentEventBus(); bus != nil {
+		bus.Publish(ctx, e)
+	}
+}
+
+// publishBlock publishes a block event with the block as it is now
+func (dm *DatabaseManager) publishBlock(ctx context.Context, t EventType, id int64) error {
+	if bus := currentEventBus(); bus == nil || !bus.subscribed(t) {
+		return nil
+	}
+	block, err := dm.FindBlock(ctx, "", id, "")
+	if err != nil || block == nil {
+		return err
+	}
+	dm.publish(ctx, Event{Type: t, Block: block})
+	return nil
+}
+
+// publishMetaChanges publishes meta.changed for each key of a parent
+func (dm *DatabaseManager) publishMetaChanges(ctx context.Context, parent string, parentID int64, keys ...string) {
+	for _, key := range keys {
+		dm.publish(ctx, Event{Type: EventMetaChanged, Parent: parent, ParentID: parentID, Key: key})
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// eventRecorder collects the events delivered to it
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) handle(ctx context.Context, e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func TestEventsAreDeliveredAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	bus := useEventBus(t)
+	var created, metas eventRecorder
+	bus.Subscribe(EventBlockCreated, created.handle)
+	bus.Subscribe(EventMetaChanged, metas.handle)
+
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id int64
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		block, err := tx.AddBlock(ctx, 1, map[string]interface{}{"type": "workspace", "title": "Shop", "content": ""}, "")
+		if err != nil {
+			return err
+		}
+		id = block["id"].(int64)
+		if err := tx.AddMeta(ctx, "workspace", id, "description", "Shoes"); err != nil {
+			return err
+		}
+		if created.count() != 0 || metas.count() != 0 {
+			t.Error("events were delivered before the commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.count() != 1 || created.events[0].Block.ID != id || created.events[0].Block.Title != "Shop" {
+		t.Errorf("block.created events = %+v", created.events)
+	}
+	if metas.count() != 1 || metas.events[0].ParentID != id || metas.events[0].Key != "description" {
+		t.Errorf("meta.changed events = %+v", metas.events)
+	}
+}
+
+func TestEventsAreDroppedOnRollback(t *testing.T) {
+	ctx := context.Background()
+	bus := useEventBus(t)
+	var recorded eventRecorder
+	for _, eventType := range []EventType{EventBlockCreated, EventMetaChanged} {
+		bus.Subscribe(eventType, recorded.handle)
+	}
+
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		block, err := tx.AddBlock(ctx, 1, map[string]interface{}{"type": "workspace", "title": "Shop", "content": ""}, "")
+		if err != nil {
+			return err
+		}
+		if err := tx.AddMeta(ctx, "workspace", block["id"].(int64), "description", "Shoes"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("WithTx = %v, want the error of fn", err)
+	}
+	if n := recorded.count(); n != 0 {
+		t.Errorf("%d events were delivered for a rolled back transaction", n)
+	}
+}
+
+func TestEventBusHandlers(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus()
+	var inline, async eventRecorder
+	bus.Subscribe(EventBlockDeleted, func(ctx context.Context, e Event) { panic("handler failed") })
+	bus.Subscribe(EventBlockDeleted, inline.handle)
+	bus.SubscribeAsync(EventBlockDeleted, async.handle)
+
+	bus.Publish(ctx, Event{Type: EventBlockDeleted, Block: &Block{ID: 1}})
+	bus.Publish(ctx, Event{Type: EventBlockUpdated, Block: &Block{ID: 1}})
+	if n := inline.count(); n != 1 {
+		t.Errorf("synchronous handler after a panicking one got %d events, want 1", n)
+	}
+	bus.Wait()
+	if n := async.count(); n != 1 {
+		t.Errorf("asynchronous handler got %d events after Wait, want 1", n)
+	}
+}
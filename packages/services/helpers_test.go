@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/miumoin/agencybot/packages/database"
+	"github.com/miumoin/agencybot/packages/migrations"
+)
+
+// openTestDB returns a migrated in-memory SQLite database
+func openTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// registerTestType registers a block type for the rest of the test
+func registerTestType(t *testing.T, def BlockTypeDefinition) {
+	t.Helper()
+	if err := RegisterBlockType(def); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		blockTypesMu.Lock()
+		defer blockTypesMu.Unlock()
+		delete(blockTypes, def.Name)
+	})
+}
+
+// useEventBus publishes the writes of the rest of the test on a new bus
+func useEventBus(t *testing.T) *EventBus {
+	t.Helper()
+	bus := NewEventBus()
+	SetEventBus(bus)
+	t.Cleanup(func() { SetEventBus(nil) })
+	return bus
+}
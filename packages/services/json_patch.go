@@ -0,0 +1,295 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is one operation of an RFC 6902 JSON Patch document
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	hasValue bool
+}
+
+// UnmarshalJSON records whether value was present, since null is a valid value
+func (o *PatchOperation) UnmarshalJSON(data []byte) error {
+	type plain PatchOperation
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, (*plain)(o)); err != nil {
+		return err
+	}
+	_, o.hasValue = fields["value"]
+	return nil
+}
+
+// ApplyMergePatch applies an RFC 7396 merge patch to doc and returns the result
+func ApplyMergePatch(doc, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = map[string]interface{}{}
+	}
+
+	result := make(map[string]interface{}, len(target))
+	for k, v := range target {
+		result[k] = v
+	}
+	for k, v := range patchObject {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = ApplyMergePatch(result[k], v)
+	}
+	return result
+}
+
+// ApplyJSONPatch applies an RFC 6902 patch to a deep copy of doc. Either every
+// operation succeeds or doc is left untouched and an error is returned.
+func ApplyJSONPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	result, err := deepCopyJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case "add":
+			if !op.hasValue {
+				err = fmt.Errorf("value is required")
+				break
+			}
+			result, err = pointerAdd(result, op.Path, op.Value)
+		case "remove":
+			result, _, err = pointerRemove(result, op.Path)
+		case "replace":
+			if !op.hasValue {
+				err = fmt.Errorf("value is required")
+				break
+			}
+			if result, _, err = pointerRemove(result, op.Path); err == nil {
+				result, err = pointerAdd(result, op.Path, op.Value)
+			}
+		case "move":
+			if op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/") {
+				err = fmt.Errorf("cannot move %s into itself", op.From)
+				break
+			}
+			var value interface{}
+			if result, value, err = pointerRemove(result, op.From); err == nil {
+				result, err = pointerAdd(result, op.Path, value)
+			}
+		case "copy":
+			var value interface{}
+			if value, err = pointerGet(result, op.From); err == nil {
+				if value, err = deepCopyJSON(value); err == nil {
+					result, err = pointerAdd(result, op.Path, value)
+				}
+			}
+		case "test":
+			var value interface{}
+			if value, err = pointerGet(result, op.Path); err == nil && !jsonEqual(value, op.Value) {
+				err = fmt.Errorf("test failed at %s", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, NewValidationError(fmt.Sprintf("patch.%d", i), err.Error())
+		}
+	}
+	return result, nil
+}
+
+func deepCopyJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
+
+// jsonEqual compares values after a JSON round trip so 1 and 1.0 match
+func jsonEqual(a, b interface{}) bool {
+	ca, errA := deepCopyJSON(a)
+	cb, errB := deepCopyJSON(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(ca, cb)
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, t := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", pointer)
+			}
+			current = value
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path %s does not exist", pointer)
+		}
+	}
+	return current, nil
+}
+
+// pointerAdd sets value at pointer, inserting into arrays, and returns the
+// possibly replaced root
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return pointerReplaceNode(doc, parentPointer, node)
+	default:
+		return nil, fmt.Errorf("path %s does not exist", parentPointer)
+	}
+}
+
+// pointerRemove deletes the value at pointer and returns the new root and the
+// removed value
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %s does not exist", pointer)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = pointerReplaceNode(doc, parentPointer, node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("path %s does not exist", pointer)
+	}
+}
+
+// pointerReplaceNode stores a resized array back into its parent
+func pointerReplaceNode(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
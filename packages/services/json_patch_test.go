@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	const doc = `{"title":"Plan","tags":["a","b"],"owner":{"name":"Ann","email":null}}`
+	tests := []struct {
+		name  string
+		patch string
+		want  string // empty when the patch must fail
+		field string // the operation reported on failure
+	}{
+		{"add to the end of an array", `[{"op":"add","path":"/tags/-","value":"c"}]`, `{"title":"Plan","tags":["a","b","c"],"owner":{"name":"Ann","email":null}}`, ""},
+		{"insert into an array", `[{"op":"add","path":"/tags/0","value":"z"}]`, `{"title":"Plan","tags":["z","a","b"],"owner":{"name":"Ann","email":null}}`, ""},
+		{"add null", `[{"op":"add","path":"/due","value":null}]`, `{"title":"Plan","tags":["a","b"],"owner":{"name":"Ann","email":null},"due":null}`, ""},
+		{"remove from an array", `[{"op":"remove","path":"/tags/0"}]`, `{"title":"Plan","tags":["b"],"owner":{"name":"Ann","email":null}}`, ""},
+		{"replace", `[{"op":"replace","path":"/owner/name","value":"Bo"}]`, `{"title":"Plan","tags":["a","b"],"owner":{"name":"Bo","email":null}}`, ""},
+		{"move", `[{"op":"move","from":"/owner/name","path":"/title"}]`, `{"title":"Ann","tags":["a","b"],"owner":{"email":null}}`, ""},
+		{"move within an array", `[{"op":"move","from":"/tags/0","path":"/tags/-"}]`, `{"title":"Plan","tags":["b","a"],"owner":{"name":"Ann","email":null}}`, ""},
+		{"copy", `[{"op":"copy","from":"/tags","path":"/labels"},{"op":"add","path":"/labels/-","value":"c"}]`, `{"title":"Plan","tags":["a","b"],"labels":["a","b","c"],"owner":{"name":"Ann","email":null}}`, ""},
+		{"test then replace", `[{"op":"test","path":"/owner/email","value":null},{"op":"replace","path":"/owner/email","value":"ann@example.com"}]`, `{"title":"Plan","tags":["a","b"],"owner":{"name":"Ann","email":"ann@example.com"}}`, ""},
+		{"failed test rolls back", `[{"op":"add","path":"/tags/-","value":"c"},{"op":"test","path":"/title","value":"Other"}]`, "", "patch.1"},
+		{"dash only appends", `[{"op":"remove","path":"/tags/-"}]`, "", "patch.0"},
+		{"index past the end", `[{"op":"add","path":"/tags/3","value":"c"}]`, "", "patch.0"},
+		{"move into itself", `[{"op":"move","from":"/owner","path":"/owner/boss"}]`, "", "patch.0"},
+		{"replace needs a value", `[{"op":"replace","path":"/title"}]`, "", "patch.0"},
+		{"missing path", `[{"op":"remove","path":"/owner/phone"}]`, "", "patch.0"},
+		{"unknown op", `[{"op":"merge","path":"/title","value":"x"}]`, "", "patch.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := decodeJSON(t, doc)
+			var ops []PatchOperation
+			if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ApplyJSONPatch(original, ops)
+			if !jsonEqual(original, decodeJSON(t, doc)) {
+				t.Errorf("the document passed in was modified: %v", original)
+			}
+			if tt.want == "" {
+				verr, ok := err.(*ValidationError)
+				if !ok || verr.Fields[tt.field] == "" {
+					t.Errorf("got %v, %v, want an error on %s", got, err, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(got, decodeJSON(t, tt.want)) {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":1,"b":2}`, `{"b":null}`, `{"a":1}`},
+		{`{"a":{"x":1,"y":2}}`, `{"a":{"y":null,"z":3}}`, `{"a":{"x":1,"z":3}}`},
+		{`{"a":1}`, `{"missing":null}`, `{"a":1}`},
+		{`{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{`{"a":1}`, `["whole"]`, `["whole"]`},
+		{`"text"`, `{"a":{"b":null,"c":1}}`, `{"a":{"c":1}}`},
+	}
+	for _, tt := range tests {
+		got := ApplyMergePatch(decodeJSON(t, tt.doc), decodeJSON(t, tt.patch))
+		if !jsonEqual(got, decodeJSON(t, tt.want)) {
+			t.Errorf("merge %s into %s = %v, want %s", tt.patch, tt.doc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var relationPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// BlockLink is a named, ordered relation from one block to another. Block
+// holds the block on the other end of the link.
+type BlockLink struct {
+	ID        int64                  `json:"id"`
+	SourceID  int64                  `json:"source_id"`
+	TargetID  int64                  `json:"target_id"`
+	Relation  string                 `json:"relation"`
+	Position  int                    `json:"position"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	Author    int64                  `json:"author"`
+	CreatedAt string                 `json:"created_at"`
+	Block     map[string]interface{} `json:"block,omitempty"`
+	other     *Block
+}
+
+// OtherBlock returns the block on the other end of the link
+func (l BlockLink) OtherBlock() *Block {
+	return l.other
+}
+
+// AllowsLink reports whether a block of this type may link to targetType
+// under relation. Types that declare no relations accept any link.
+func (d BlockTypeDefinition) AllowsLink(relation, targetType string) bool {
+	if len(d.Relations) == 0 {
+		return true
+	}
+	targets, ok := d.Relations[relation]
+	if !ok {
+		return false
+	}
+	if len(targets) == 0 {
+		return true
+	}
+	for _, t := range targets {
+		if t == targetType {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLink links source to target under relation, updating position and
+// metadata when the link already exists
+func (dm *DatabaseManager) AddLink(ctx context.Context, sourceID, targetID int64, relation string, position int, metadata map[string]interface{}) (*BlockLink, error) {
+	verr := &ValidationError{}
+	if !relationPattern.MatchString(relation) {
+		verr.Add("relation", "must be lowercase letters, digits and underscores")
+	}
+	if sourceID == targetID {
+		verr.Add("target", "a block cannot link to itself")
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	source, err := dm.FindBlock(ctx, "", sourceID, "")
+	if err != nil {
+		return nil, err
+	}
+	target, err := dm.FindBlock(ctx, "", targetID, "")
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, NewValidationError("source", "not found")
+	}
+	if target == nil {
+		return nil, NewValidationError("target", "not found")
+	}
+	if def, ok := GetBlockTypeDefinition(source.Type); ok && !def.AllowsLink(relation, target.Type) {
+		return nil, NewValidationError("relation", fmt.Sprintf("%s cannot link to %s as %q", source.Type, target.Type, relation))
+	}
+
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dm.q().ExecContext(ctx,
+		dm.db.Dialect.Upsert("block_links",
+			[]string{"source_id", "target_id", "relation", "position", "metadata", "author", "created_at"},
+			[]string{"source_id", "target_id", "relation"},
+			[]string{"position", "metadata"},
+		),
+		sourceID, targetID, relation, position, string(metadataJSON), dm.userID,
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := dm.queryLinks(ctx,
+		"l.source_id = ? AND l.target_id = ? AND l.relation = ?", "l.target_id",
+		sourceID, targetID, relation,
+	)
+	if err != nil || len(links) == 0 {
+		return nil, err
+	}
+	return &links[0], nil
+}
+
+// RemoveLink deletes a link between two blocks
+func (dm *DatabaseManager) RemoveLink(ctx context.Context, sourceID, targetID int64, relation string) error {
+	_, err := dm.q().ExecContext(ctx,
+		"DELETE FROM block_links WHERE source_id = ? AND target_id = ? AND relation = ?",
+		sourceID, targetID, relation,
+	)
+	return err
+}
+
+// GetLinks lists the links of a block whose other end is active. Outgoing
+// links start at the block, incoming links point at it. An empty relation
+// returns links of every relation.
+func (dm *DatabaseManager) GetLinks(ctx context.Context, blockID int64, relation string, incoming bool) ([]BlockLink, error) {
+	where, join := "l.source_id = ?", "l.target_id"
+	if incoming {
+		where, join = "l.target_id = ?", "l.source_id"
+	}
+	args := []interface{}{blockID}
+	if relation != "" {
+		where += " AND l.relation = ?"
+		args = append(args, relation)
+	}
+	return dm.queryLinks(ctx, where, join, args...)
+}
+
+// queryLinks selects links joined with the block on the otherColumn side
+func (dm *DatabaseManager) queryLinks(ctx context.Context, where, otherColumn string, args ...interface{}) ([]BlockLink, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT l.id, l.source_id, l.target_id, l.relation, l.position, l.metadata, l.author, l.created_at, "+
+			qualifiedBlockColumns+" "+
+			"FROM block_links l INNER JOIN blocks b ON b.id = "+otherColumn+
+			" WHERE "+where+" AND b.status = 1 ORDER BY l.relation, l.position, l.id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []BlockLink{}
+	for rows.Next() {
+		var l BlockLink
+		var b Block
+		var metadataJSON string
+		err := rows.Scan(
+			&l.ID, &l.SourceID, &l.TargetID, &l.Relation, &l.Position, &metadataJSON, &l.Author, &l.CreatedAt,
+			&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt, &b.Position, &b.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Metadata = map[string]interface{}{}
+		if metadataJSON != "" {
+			if err := json.Unmarshal([]byte(metadataJSON), &l.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		l.CreatedAt = FormatTimeToISO(l.CreatedAt)
+		l.other = &b
+		l.Block = BlockToMap(&b)
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
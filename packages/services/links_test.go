@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAllowsLink(t *testing.T) {
+	def := BlockTypeDefinition{Name: "thread", Relations: map[string][]string{
+		"uses":    {"knowledge", "chunk"},
+		"related": {},
+	}}
+	tests := []struct {
+		relation, target string
+		want             bool
+	}{
+		{"uses", "knowledge", true},
+		{"uses", "chunk", true},
+		{"uses", "thread", false},
+		{"related", "thread", true},
+		{"cites", "knowledge", false},
+	}
+	for _, tt := range tests {
+		if got := def.AllowsLink(tt.relation, tt.target); got != tt.want {
+			t.Errorf("AllowsLink(%q, %q) = %v, want %v", tt.relation, tt.target, got, tt.want)
+		}
+	}
+	if !(BlockTypeDefinition{Name: "workspace"}).AllowsLink("anything", "thread") {
+		t.Error("a type without relations refused a link")
+	}
+}
+
+func TestAddLinkValidatesRelations(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := addWorkspace(t, dm, "Support")
+	add := func(blockType string) int64 {
+		t.Helper()
+		block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": blockType, "title": blockType, "content": "", "parent": workspace}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block["id"].(int64)
+	}
+	thread, other, knowledge := add("thread"), add("thread"), add("knowledge")
+
+	tests := []struct {
+		name            string
+		source, target  int64
+		relation, field string
+	}{
+		{"undeclared target type", thread, other, "uses", "relation"},
+		{"undeclared relation", thread, knowledge, "cites", "relation"},
+		{"malformed relation", thread, knowledge, "Uses", "relation"},
+		{"link to itself", thread, thread, "uses", "target"},
+		{"missing target", thread, 999999, "uses", "target"},
+		{"missing source", 999999, knowledge, "uses", "source"},
+	}
+	for _, tt := range tests {
+		_, err := dm.AddLink(ctx, tt.source, tt.target, tt.relation, 0, nil)
+		verr, ok := err.(*ValidationError)
+		if !ok || verr.Fields[tt.field] == "" {
+			t.Errorf("%s: got %v, want an error on %s", tt.name, err, tt.field)
+		}
+	}
+
+	// Linking again updates the link instead of adding another
+	if _, err := dm.AddLink(ctx, thread, knowledge, "uses", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	link, err := dm.AddLink(ctx, thread, knowledge, "uses", 2, map[string]interface{}{"note": "pricing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Position != 2 || link.Metadata["note"] != "pricing" || link.OtherBlock() == nil || link.OtherBlock().ID != knowledge {
+		t.Errorf("updated link = %+v", link)
+	}
+	// Workspaces declare no relations, so they may link to anything
+	if _, err := dm.AddLink(ctx, workspace, thread, "pinned", 0, nil); err != nil {
+		t.Errorf("workspace link: %v", err)
+	}
+
+	outgoing, err := dm.GetLinks(ctx, thread, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incoming, err := dm.GetLinks(ctx, knowledge, "uses", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outgoing) != 1 || len(incoming) != 1 || incoming[0].SourceID != thread {
+		t.Errorf("outgoing %+v, incoming %+v, want the one link", outgoing, incoming)
+	}
+
+	if err := dm.RemoveLink(ctx, thread, knowledge, "uses"); err != nil {
+		t.Fatal(err)
+	}
+	if links, _ := dm.GetLinks(ctx, thread, "", false); len(links) != 0 {
+		t.Errorf("links after removing = %+v", links)
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+var (
+	metaSortPattern   = regexp.MustCompile(`^meta\.([A-Za-z0-9_\-]{1,120})$`)
+	metaKeyPattern    = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,120}$`)
+	metaFilterPattern = regexp.MustCompile(`^meta\.([A-Za-z0-9_\-]{1,120})((?:\.[A-Za-z0-9_]+)*)(?:\[([a-z]+)\])?$`)
+)
+
+// Meta filter operators
+const (
+	FilterEq     = "eq"
+	FilterNe     = "ne"
+	FilterGt     = "gt"
+	FilterGte    = "gte"
+	FilterLt     = "lt"
+	FilterLte    = "lte"
+	FilterIn     = "in"
+	FilterExists = "exists"
+)
+
+var rangeOperators = map[string]string{FilterGt: ">", FilterGte: ">=", FilterLt: "<", FilterLte: "<="}
+
+// maxMetaFilters bounds the number of correlated subqueries per list query
+const maxMetaFilters = 10
+
+// MetaFilter matches blocks on an active meta. Path selects a value inside a
+// JSON meta, e.g. Key "collected_information" and Path ["stage"].
+type MetaFilter struct {
+	Key    string
+	Path   []string
+	Op     string
+	Values []string
+}
+
+// ListOptions controls how block list queries are filtered and ordered and
+// which metas are returned with each block
+type ListOptions struct {
+	Sort    string // id, position, created_at, modified_at, title or meta.<key>
+	Desc    bool
+	MetaKey string // set when sorting by a meta value
+
+	Filters []MetaFilter
+	Metas   []string // meta keys to include, "*" for all
+
+	After     *ListCursor // continue after this row instead of using pages
+	Limit     int         // page size, DefaultPageSize when zero
+	WithTotal bool        // also count all matching rows
+
+	numeric bool // the sort meta is declared a number by the listed type
+}
+
+// NewListOptions validates a sort field and direction coming from a request.
+// An empty sort keeps the default newest-first order.
+func NewListOptions(sort, order string) (ListOptions, error) {
+	opts := ListOptions{Sort: strings.TrimSpace(sort)}
+
+	switch opts.Sort {
+	case "", "id", "created_at", "modified_at":
+		opts.Desc = true
+	case "position", "title":
+	default:
+		m := metaSortPattern.FindStringSubmatch(opts.Sort)
+		if m == nil {
+			return opts, NewValidationError("sort", "must be one of id, position, created_at, modified_at, title or meta.<key>")
+		}
+		opts.MetaKey = m[1]
+	}
+
+	switch strings.ToLower(strings.TrimSpace(order)) {
+	case "":
+	case "asc":
+		opts.Desc = false
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, NewValidationError("order", "must be asc or desc")
+	}
+	return opts, nil
+}
+
+// ParseListOptions reads sort, order, metas, meta filters and the after,
+// limit and total pagination parameters from a query string. Filters look like meta.status=open, meta.priority[gte]=3,
+// meta.status[in]=open,pending, meta.owner[exists]=false or
+// meta.collected_information.stage=interview.
+func ParseListOptions(query url.Values) (ListOptions, error) {
+	opts, err := NewListOptions(query.Get("sort"), query.Get("order"))
+	if err != nil {
+		return opts, err
+	}
+	verr := &ValidationError{}
+	if strings.HasPrefix(opts.MetaKey, "privilege_") {
+		verr.Add("sort", "privilege metas cannot be sorted on")
+	} else if isSecretMetaKey(opts.MetaKey) {
+		verr.Add("sort", "secret metas cannot be sorted on")
+	}
+	for field, message := range parsePagination(&opts, query.Get("after"), query.Get("limit"), query.Get("total")).Fields {
+		verr.Add(field, message)
+	}
+
+	for _, key := range strings.Split(query.Get("metas"), ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case key == "":
+		case key == "*":
+			opts.Metas = []string{"*"}
+		case !metaKeyPattern.MatchString(key) || strings.HasPrefix(key, "privilege_"):
+			verr.Add("metas", fmt.Sprintf("invalid meta key %q", key))
+		case isSecretMetaKey(key):
+			verr.Add("metas", fmt.Sprintf("secret meta %q cannot be selected", key))
+		case len(opts.Metas) == 0 || opts.Metas[0] != "*":
+			opts.Metas = append(opts.Metas, key)
+		}
+	}
+
+	// Sorted so filters render in a stable order
+	params := make([]string, 0, len(query))
+	for param := range query {
+		if strings.HasPrefix(param, "meta.") {
+			params = append(params, param)
+		}
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		filter, err := parseMetaFilter(param, query.Get(param))
+		if err != "" {
+			verr.Add(param, err)
+			continue
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+	if len(opts.Filters) > maxMetaFilters {
+		verr.Add("meta", fmt.Sprintf("at most %d meta filters are allowed", maxMetaFilters))
+	}
+	return opts, verr.OrNil()
+}
+
+func parseMetaFilter(param, value string) (MetaFilter, string) {
+	m := metaFilterPattern.FindStringSubmatch(param)
+	if m == nil {
+		return MetaFilter{}, "invalid meta filter"
+	}
+	filter := MetaFilter{Key: m[1], Op: m[3], Values: []string{value}}
+	if strings.HasPrefix(filter.Key, "privilege_") {
+		return filter, "privilege metas cannot be filtered on"
+	}
+	if isSecretMetaKey(filter.Key) {
+		return filter, "secret metas cannot be filtered on"
+	}
+	if m[2] != "" {
+		filter.Path = strings.Split(strings.TrimPrefix(m[2], "."), ".")
+	}
+	if filter.Op == "" {
+		filter.Op = FilterEq
+	}
+
+	switch filter.Op {
+	case FilterEq, FilterNe:
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return filter, "must be a number"
+		}
+	case FilterIn:
+		filter.Values = nil
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				filter.Values = append(filter.Values, v)
+			}
+		}
+		if len(filter.Values) == 0 {
+			return filter, "needs at least one value"
+		}
+	case FilterExists:
+		if value != "true" && value != "false" {
+			return filter, "must be true or false"
+		}
+	default:
+		return filter, fmt.Sprintf("unknown operator %q", filter.Op)
+	}
+	return filter, ""
+}
+
+// where renders the meta filters as AND conditions on blocks aliased as table
+func (o ListOptions) where(d database.Dialect, table string) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+
+	for _, f := range o.Filters {
+		value := "m.meta_value"
+		var valueArgs []interface{}
+		if len(f.Path) > 0 {
+			value = d.JSONText("m.meta_value")
+			valueArgs = append(valueArgs, d.JSONPath(f.Path))
+		}
+
+		exists := "EXISTS"
+		condition := ""
+		var conditionArgs []interface{}
+		switch f.Op {
+		case FilterEq, FilterNe:
+			condition = " AND " + value + " = ?"
+			conditionArgs = append(valueArgs, f.Values[0])
+			if f.Op == FilterNe {
+				exists = "NOT EXISTS"
+			}
+		case FilterGt, FilterGte, FilterLt, FilterLte:
+			number, _ := strconv.ParseFloat(f.Values[0], 64)
+			condition = " AND " + d.CastDecimal(value) + " " + rangeOperators[f.Op] + " ?"
+			conditionArgs = append(valueArgs, number)
+		case FilterIn:
+			condition = " AND " + value + " IN (" + placeholders(len(f.Values)) + ")"
+			conditionArgs = valueArgs
+			for _, v := range f.Values {
+				conditionArgs = append(conditionArgs, v)
+			}
+		case FilterExists:
+			if len(f.Path) > 0 {
+				condition = " AND " + value + " IS NOT NULL"
+				conditionArgs = valueArgs
+			}
+			if f.Values[0] == "false" {
+				exists = "NOT EXISTS"
+			}
+		}
+
+		fmt.Fprintf(&clause,
+			" AND %s (SELECT 1 FROM metas m WHERE m.parent = %[2]s.type AND m.parent_id = %[2]s.id AND m.meta_key = ? AND m.status = 1%[3]s)",
+			exists, table, condition,
+		)
+		args = append(args, f.Key)
+		args = append(args, conditionArgs...)
+	}
+	return clause.String(), args
+}
+
+// orderBy renders the ORDER BY clause for a query over blocks aliased as
+// table. Ties are broken by id so pages and cursors are stable; a missing sort
+// meta sorts as an empty string, or as zero when it is a number.
+func (o ListOptions) orderBy(d database.Dialect, table string) (string, []interface{}) {
+	direction := "ASC"
+	if o.Desc {
+		direction = "DESC"
+	}
+	if o.Sort == "" || o.Sort == "id" {
+		return fmt.Sprintf(" ORDER BY %s.id %s", table, direction), nil
+	}
+	expr, args := o.sortExpr(d, table)
+	return fmt.Sprintf(" ORDER BY %[1]s %[2]s, %[3]s.id %[2]s", expr, direction, table), args
+}
+
+// attachMetas loads the metas selected by opts for a page of blocks in a single
+// query and stores them under each block's "metas"
+func (dm *DatabaseManager) attachMetas(ctx context.Context, blocks []map[string]interface{}, opts ListOptions) error {
+	for _, block := range blocks {
+		block["metas"] = map[string]string{}
+	}
+	if len(blocks) == 0 || len(opts.Metas) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]map[string]interface{}, len(blocks))
+	ids := make([]int64, 0, len(blocks))
+	for _, block := range blocks {
+		id := block["id"].(int64)
+		byID[id] = block
+		ids = append(ids, id)
+	}
+
+	query := "SELECT parent, parent_id, meta_key, meta_value FROM metas WHERE status = 1 AND parent_id IN (" + placeholders(len(ids)) + ")"
+	args := int64Args(ids)
+	if opts.Metas[0] == "*" {
+		query += " AND SUBSTR(meta_key, 1, 10) <> 'privilege_'"
+	} else {
+		query += " AND meta_key IN (" + placeholders(len(opts.Metas)) + ")"
+		for _, key := range opts.Metas {
+			args = append(args, key)
+		}
+	}
+
+	rows, err := dm.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parent, key, value string
+		var parentID int64
+		if err := rows.Scan(&parent, &parentID, &key, &value); err != nil {
+			return err
+		}
+		// metas of other parent types can share the id
+		if block, ok := byID[parentID]; ok && block["type"] == parent {
+			if isSecretMeta(parent, key) {
+				value = maskSecret(value)
+			}
+			block["metas"].(map[string]string)[key] = value
+		}
+	}
+	return rows.Err()
+}
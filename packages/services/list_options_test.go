@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+func TestPostgresMetaFiltersGuardCasts(t *testing.T) {
+	opts, err := ParseListOptions(url.Values{
+		"meta.questionnaire.stage": {"lead"},
+		"meta.description[gt]":     {"5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, args := opts.where(database.Postgres, "b")
+
+	if !strings.Contains(where, "try_jsonb(m.meta_value) #>> CAST(? AS text[])") {
+		t.Errorf("jsonb cast is not guarded: %s", where)
+	}
+	if !strings.Contains(where, `CAST(SUBSTRING(m.meta_value FROM '^\s*(-?[0-9]+(?:\.[0-9]+)?)\s*$') AS DECIMAL(30,10)) > ?`) {
+		t.Errorf("decimal cast is not guarded: %s", where)
+	}
+	if n := len(regexp.MustCompile(`\$[0-9]+`).FindAllString(database.Postgres.Rebind(where), -1)); n != len(args) {
+		t.Errorf("%d placeholders for %d arguments: %s", n, len(args), where)
+	}
+}
+
+func TestMetaFiltersSkipValuesOfTheWrongShape(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metas written before their type was declared can hold anything
+	for title, metas := range map[string][2]string{
+		"Lead":    {`{"stage":"lead"}`, "10"},
+		"Garbled": {`{"stage":`, "ten"},
+		"Plain":   {"lead", "3"},
+	} {
+		id := addWorkspace(t, dm, title)
+		for key, value := range map[string]string{"questionnaire": metas[0], "description": metas[1]} {
+			if _, err := db.Exec("INSERT INTO metas (parent, parent_id, meta_key, meta_value, status) VALUES ('workspace', ?, ?, ?, 1)", id, key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for query, want := range map[string]string{
+		"meta.questionnaire.stage=lead": "Lead",
+		"meta.description[gt]=5":        "Lead",
+	} {
+		values, _ := url.ParseQuery(query)
+		opts, err := ParseListOptions(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := dm.GetBlocks(ctx, 1, "workspace", 1, 0, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if len(page.Blocks) != 1 || page.Blocks[0]["title"] != want {
+			t.Errorf("%s: got %v, want only %s", query, page.Blocks, want)
+		}
+	}
+}
+
+func TestListOptionsRefuseSecretMetas(t *testing.T) {
+	for query, field := range map[string]string{
+		"sort=meta.stripe_secret_key":                  "sort",
+		"metas=title,stripe_secret_key":                "metas",
+		"meta.stripe_secret_key=sk_live_1234":          "meta.stripe_secret_key",
+		"meta.stripe_secret_key[gte]=0":                "meta.stripe_secret_key[gte]",
+		"meta.stripe_secret_key.account[exists]=false": "meta.stripe_secret_key.account[exists]",
+	} {
+		values, _ := url.ParseQuery(query)
+		_, err := ParseListOptions(values)
+		verr, ok := err.(*ValidationError)
+		if !ok || !strings.Contains(verr.Fields[field], "secret") {
+			t.Errorf("%s: got %v, want a secret error on %s", query, err, field)
+		}
+	}
+}
+
+func TestNumericMetasSortAsNumbers(t *testing.T) {
+	ctx := context.Background()
+	registerTestType(t, BlockTypeDefinition{
+		Name:  "product",
+		Metas: []MetaField{{Name: "price", Type: MetaNumber}},
+	})
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]int64{}
+	for _, title := range []string{"Ten", "Nine", "Hundred", "Unpriced", "Nine again"} {
+		block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "product", "title": title, "content": ""}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[title] = block["id"].(int64)
+	}
+	for title, price := range map[string]float64{"Ten": 10, "Nine": 9, "Hundred": 100, "Nine again": 9} {
+		if err := dm.AddMeta(ctx, "product", ids[title], "price", price); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pages of two, following the cursor, so the keyset compares numbers too
+	var got []int64
+	query := url.Values{"sort": {"meta.price"}, "order": {"asc"}, "limit": {"2"}}
+	for {
+		opts, err := ParseListOptions(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := dm.GetBlocks(ctx, 1, "product", 1, 0, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, block := range page.Blocks {
+			got = append(got, block["id"].(int64))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("after", page.NextCursor)
+	}
+
+	want := []int64{ids["Unpriced"], ids["Nine"], ids["Nine again"], ids["Ten"], ids["Hundred"]}
+	if !sameIDs(got, want) {
+		t.Errorf("sorted by price = %v, want %v", got, want)
+	}
+}
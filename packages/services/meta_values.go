@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var metaPathSegment = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,120}$`)
+
+// GetMetaJSON decodes a JSON meta into T. The boolean is false when the meta
+// is missing or empty.
+func GetMetaJSON[T any](ctx context.Context, dm *DatabaseManager, parent string, parentID int64, key string) (T, bool, error) {
+	var result T
+	raw, err := dm.GetMeta(ctx, parent, parentID, key)
+	if err != nil || raw == "" {
+		return result, false, err
+	}
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return result, false, fmt.Errorf("meta %s is not valid JSON for %T: %v", key, result, err)
+	}
+	return result, true, nil
+}
+
+// GetMetaInt parses an integer meta, returning 0 when it is missing
+func (dm *DatabaseManager) GetMetaInt(ctx context.Context, parent string, parentID int64, key string) (int64, error) {
+	raw, err := dm.GetMeta(ctx, parent, parentID, key)
+	if err != nil || strings.TrimSpace(raw) == "" {
+		return 0, err
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("meta %s is not an integer: %v", key, err)
+	}
+	return value, nil
+}
+
+// SetMetaJSON stores value as JSON, even when it is a string
+func (dm *DatabaseManager) SetMetaJSON(ctx context.Context, parent string, parentID int64, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return dm.AddMeta(ctx, parent, parentID, key, string(encoded))
+}
+
+// ParseMetaPath splits a dotted path such as "stage" or "contact.email"
+func ParseMetaPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	segments := strings.Split(path, ".")
+	for _, s := range segments {
+		if !metaPathSegment.MatchString(s) {
+			return nil, NewValidationError("path", fmt.Sprintf("invalid path segment %q", s))
+		}
+	}
+	return segments, nil
+}
+
+// GetMetaPath reads the value at path inside a JSON object meta. The boolean
+// is false when the meta or the path does not exist.
+func (dm *DatabaseManager) GetMetaPath(ctx context.Context, parent string, parentID int64, key string, path []string) (interface{}, bool, error) {
+	value, ok, err := GetMetaJSON[interface{}](ctx, dm, parent, parentID, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	for _, segment := range path {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return nil, false, nil
+		}
+		if value, ok = object[segment]; !ok {
+			return nil, false, nil
+		}
+	}
+	return value, true, nil
+}
+
+// PatchMetaPath sets the value at path inside a JSON object meta, creating
+// intermediate objects as needed. A nil value removes the field. The meta row
+// is locked while it is rewritten so concurrent patches to other fields are
+// not lost.
+func (dm *DatabaseManager) PatchMetaPath(ctx context.Context, parent string, parentID int64, key string, path []string, value interface{}) (map[string]interface{}, error) {
+	if len(path) == 0 {
+		return nil, NewValidationError("path", "is required")
+	}
+	if isSecretMeta(parent, key) {
+		return nil, NewValidationError("metas."+key, "secret metas cannot be patched by path")
+	}
+	def, isBlock := GetBlockTypeDefinition(parent)
+
+	var document map[string]interface{}
+	err := dm.WithTx(ctx, func(tx *Tx) error {
+		if isBlock {
+			if err := tx.lockRevisions(ctx, parentID); err != nil {
+				return err
+			}
+		}
+
+		// A no-op update takes the row lock before the value is read
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE metas SET meta_value = meta_value WHERE parent = ? AND parent_id = ? AND meta_key = ?",
+			parent, parentID, key,
+		); err != nil {
+			return err
+		}
+
+		var raw string
+		var status int
+		err := tx.QueryRowContext(ctx,
+			"SELECT meta_value, status FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ?",
+			parent, parentID, key,
+		).Scan(&raw, &status)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		document = map[string]interface{}{}
+		if err == nil && status == StatusActive && strings.TrimSpace(raw) != "" {
+			if err := json.Unmarshal([]byte(raw), &document); err != nil {
+				return NewValidationError("metas."+key, "is not a JSON object")
+			}
+		}
+
+		object := document
+		for i, segment := range path[:len(path)-1] {
+			child, ok := object[segment].(map[string]interface{})
+			if !ok {
+				if _, exists := object[segment]; exists && object[segment] != nil {
+					return NewValidationError("path", fmt.Sprintf("%s is not an object", strings.Join(path[:i+1], ".")))
+				}
+				child = map[string]interface{}{}
+				object[segment] = child
+			}
+			object = child
+		}
+		last := path[len(path)-1]
+		if value == nil {
+			delete(object, last)
+		} else {
+			object[last] = value
+		}
+
+		encoded, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		if isBlock {
+			if err := def.ValidateMeta(key, string(encoded)); err != nil {
+				return NewValidationError("metas."+key, err.Error())
+			}
+		}
+		if err := tx.addMeta(ctx, parent, parentID, key, string(encoded)); err != nil {
+			return err
+		}
+		if isBlock {
+			if err := bumpVersion(ctx, tx, parentID); err != nil {
+				return err
+			}
+			if err := tx.recordRevision(ctx, parentID, tx.userID); err != nil {
+				return err
+			}
+		}
+		tx.publishMetaChanges(ctx, parent, parentID, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
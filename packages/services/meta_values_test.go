@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestParseMetaPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		want  []string
+		valid bool
+	}{
+		{"", nil, true},
+		{"stage", []string{"stage"}, true},
+		{"contact.email", []string{"contact", "email"}, true},
+		{"contact..email", nil, false},
+		{"contact.", nil, false},
+		{"a b", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMetaPath(tt.path)
+		if tt.valid != (err == nil) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("ParseMetaPath(%q) = %q, %v", tt.path, got, err)
+		}
+	}
+}
+
+func TestMetaPathReadAndPatch(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Leads")
+	get := func(path ...string) (interface{}, bool) {
+		t.Helper()
+		value, ok, err := dm.GetMetaPath(ctx, "workspace", id, "questionnaire", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value, ok
+	}
+
+	if _, ok := get("stage"); ok {
+		t.Error("found a path in a meta that does not exist")
+	}
+
+	// Intermediate objects are created
+	if _, err := dm.PatchMetaPath(ctx, "workspace", id, "questionnaire", []string{"contact", "email"}, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dm.PatchMetaPath(ctx, "workspace", id, "questionnaire", []string{"stage"}, "lead"); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := get("contact", "email"); !ok || value != "ann@example.com" {
+		t.Errorf("contact.email = %v, %v", value, ok)
+	}
+	if value, ok := get("stage"); !ok || value != "lead" {
+		t.Errorf("stage = %v, %v", value, ok)
+	}
+	if _, ok := get("stage", "name"); ok {
+		t.Error("found a path below a string")
+	}
+
+	// Patching below a value that is not an object is refused
+	if _, err := dm.PatchMetaPath(ctx, "workspace", id, "questionnaire", []string{"stage", "name"}, "x"); err == nil {
+		t.Error("patched a path below a string")
+	}
+
+	// A nil value removes the field and keeps the rest
+	document, err := dm.PatchMetaPath(ctx, "workspace", id, "questionnaire", []string{"contact", "email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(document) != "map[contact:map[] stage:lead]" {
+		t.Errorf("after removing contact.email = %v", document)
+	}
+
+	if err := dm.AddMeta(ctx, "workspace", id, "description", "plain text"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dm.PatchMetaPath(ctx, "workspace", id, "description", []string{"x"}, "y"); err == nil {
+		t.Error("patched a path inside a meta that is not a JSON object")
+	}
+	if _, err := dm.PatchMetaPath(ctx, "workspace", id, "stripe_secret_key", []string{"x"}, "y"); err == nil {
+		t.Error("patched a path inside a secret meta")
+	}
+}
+
+func TestConcurrentMetaPathPatchesKeepEveryField(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Leads")
+
+	const fields = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, fields)
+	for i := 0; i < fields; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := dm.PatchMetaPath(ctx, "workspace", id, "questionnaire", []string{fmt.Sprintf("q%d", i)}, i)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	document, _, err := GetMetaJSON[map[string]interface{}](ctx, dm, "workspace", id, "questionnaire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(document) != fields {
+		t.Errorf("got %v, want all %d fields", document, fields)
+	}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBatchIDs bounds the size of IN lists in batched meta reads
+const maxBatchIDs = 500
+
+// SetMetas writes several metas on one parent in a single transaction. A nil
+// value deletes the meta. Values are validated against the block type first,
+// and block metas get a single revision for the whole batch.
+func (dm *DatabaseManager) SetMetas(ctx context.Context, parent string, parentID int64, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	def, isBlock := GetBlockTypeDefinition(parent)
+	if isBlock {
+		verr := &ValidationError{}
+		for key, value := range values {
+			if value == nil {
+				continue
+			}
+			if err := def.ValidateMeta(key, value); err != nil {
+				verr.Add("metas."+key, err.Error())
+			}
+		}
+		if err := verr.OrNil(); err != nil {
+			return err
+		}
+	}
+
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		if isBlock {
+			if err := tx.lockRevisions(ctx, parentID); err != nil {
+				return err
+			}
+		}
+
+		var deleted []string
+		for key, value := range values {
+			if value == nil {
+				deleted = append(deleted, key)
+				continue
+			}
+			if err := tx.addMeta(ctx, parent, parentID, key, value); err != nil {
+				return err
+			}
+		}
+		if err := deleteMetas(ctx, tx, parent, parentID, deleted); err != nil {
+			return err
+		}
+		if isBlock {
+			if err := bumpVersion(ctx, tx, parentID); err != nil {
+				return err
+			}
+			if err := tx.recordRevision(ctx, parentID, tx.userID); err != nil {
+				return err
+			}
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		tx.publishMetaChanges(ctx, parent, parentID, keys...)
+		return nil
+	})
+}
+
+// DeleteMetas marks metas of a parent as deleted (status 0) in one transaction
+func (dm *DatabaseManager) DeleteMetas(ctx context.Context, parent string, parentID int64, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		values[key] = nil
+	}
+	return dm.SetMetas(ctx, parent, parentID, values)
+}
+
+func deleteMetas(ctx context.Context, q queryer, parent string, parentID int64, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []interface{}{StatusTrashed, parent, parentID}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	_, err := q.ExecContext(ctx,
+		"UPDATE metas SET status = ? WHERE parent = ? AND parent_id = ? AND status = 1 AND meta_key IN ("+placeholders(len(keys))+")",
+		args...,
+	)
+	return err
+}
+
+// GetMetasFor fetches the active metas of many parents of one type at once,
+// keyed by parent id. An empty keys list returns every meta.
+func (dm *DatabaseManager) GetMetasFor(ctx context.Context, parent string, ids []int64, keys []string) (map[int64]map[string]string, error) {
+	result := make(map[int64]map[string]string, len(ids))
+	for _, id := range ids {
+		result[id] = map[string]string{}
+	}
+
+	for start := 0; start < len(ids); start += maxBatchIDs {
+		end := start + maxBatchIDs
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := ids[start:end]
+
+		query := "SELECT parent_id, meta_key, meta_value FROM metas WHERE parent = ? AND status = 1 AND parent_id IN (" + placeholders(len(chunk)) + ")"
+		args := append([]interface{}{parent}, int64Args(chunk)...)
+		if len(keys) > 0 {
+			query += " AND meta_key IN (" + placeholders(len(keys)) + ")"
+			for _, key := range keys {
+				args = append(args, key)
+			}
+		}
+
+		rows, err := dm.q().QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var key, value string
+			if err := rows.Scan(&id, &key, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			result[id][key] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, metas := range result {
+		maskSecretMetas(parent, metas)
+	}
+	return result, nil
+}
+
+// IncrementMeta atomically adds delta to a numeric meta and returns the new
+// value. A missing meta counts as zero.
+func (dm *DatabaseManager) IncrementMeta(ctx context.Context, parent string, parentID int64, key string, delta float64) (float64, error) {
+	if isSecretMeta(parent, key) {
+		return 0, NewValidationError("metas."+key, "secret metas cannot be incremented")
+	}
+	def, isBlock := GetBlockTypeDefinition(parent)
+
+	var value float64
+	err := dm.WithTx(ctx, func(tx *Tx) error {
+		// A no-op update takes the row lock before the value is read
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE metas SET meta_value = meta_value WHERE parent = ? AND parent_id = ? AND meta_key = ?",
+			parent, parentID, key,
+		); err != nil {
+			return err
+		}
+
+		var current string
+		var status int
+		err := tx.QueryRowContext(ctx,
+			"SELECT meta_value, status FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ?",
+			parent, parentID, key,
+		).Scan(&current, &status)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if err == nil && status == StatusActive && strings.TrimSpace(current) != "" {
+			value, err = strconv.ParseFloat(strings.TrimSpace(current), 64)
+			if err != nil {
+				return NewValidationError("metas."+key, "is not a number")
+			}
+		}
+		value += delta
+
+		encoded := strconv.FormatFloat(value, 'f', -1, 64)
+		if isBlock {
+			if err := def.ValidateMeta(key, encoded); err != nil {
+				return NewValidationError("metas."+key, err.Error())
+			}
+		}
+		if err := tx.addMeta(ctx, parent, parentID, key, encoded); err != nil {
+			return err
+		}
+		if isBlock {
+			if err := bumpVersion(ctx, tx, parentID); err != nil {
+				return err
+			}
+		}
+		tx.publishMetaChanges(ctx, parent, parentID, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
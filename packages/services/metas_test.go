@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestSetMetasWritesOneRevisionPerBatch(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Docs")
+	revisionCount := func() int {
+		t.Helper()
+		revisions, err := dm.GetRevisions(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(revisions)
+	}
+	metas := func() map[string]string {
+		t.Helper()
+		metas, err := dm.GetMetasFor(ctx, "workspace", []int64{id}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metas[id]
+	}
+
+	if err := dm.SetMetas(ctx, "workspace", id, map[string]interface{}{"description": "Manuals", "prompt": "Be brief"}); err != nil {
+		t.Fatal(err)
+	}
+	before := revisionCount()
+
+	if err := dm.SetMetas(ctx, "workspace", id, map[string]interface{}{"description": nil, "prompt": "Be kind", "collect_information": true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := revisionCount(); got != before+1 {
+		t.Errorf("a batch of three metas made %d revisions, want 1", got-before)
+	}
+	got := metas()
+	if _, ok := got["description"]; ok || got["prompt"] != "Be kind" || got["collect_information"] == "" {
+		t.Errorf("metas after the batch = %v", got)
+	}
+
+	// One invalid value rejects the whole batch
+	err = dm.SetMetas(ctx, "workspace", id, map[string]interface{}{"prompt": "Be loud", "collect_information": "maybe"})
+	verr, ok := err.(*ValidationError)
+	if !ok || verr.Fields["metas.collect_information"] == "" {
+		t.Fatalf("got %v, want a validation error on metas.collect_information", err)
+	}
+	if got := metas(); got["prompt"] != "Be kind" || revisionCount() != before+1 {
+		t.Errorf("a rejected batch was written: %v", got)
+	}
+
+	if err := dm.DeleteMetas(ctx, "workspace", id, []string{"prompt", "collect_information"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := metas(); len(got) != 0 {
+		t.Errorf("metas after deleting them all = %v", got)
+	}
+}
+
+func TestGetMetasForFiltersKeysAndMasksSecrets(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSecretKeys(t)
+
+	withMetas := addWorkspace(t, dm, "Shop")
+	without := addWorkspace(t, dm, "Empty")
+	if err := dm.SetMetas(ctx, "workspace", withMetas, map[string]interface{}{
+		"description":       "Shoes",
+		"prompt":            "Sell",
+		"stripe_secret_key": "sk_live_AAAA1111secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	metas, err := dm.GetMetasFor(ctx, "workspace", []int64{withMetas, without}, []string{"description", "stripe_secret_key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 || len(metas[without]) != 0 {
+		t.Errorf("got %v, want an empty map for the workspace without metas", metas)
+	}
+	got := metas[withMetas]
+	if len(got) != 2 || got["description"] != "Shoes" {
+		t.Errorf("filtered metas = %v", got)
+	}
+	if got["stripe_secret_key"] == "" || got["stripe_secret_key"] == "sk_live_AAAA1111secret" {
+		t.Errorf("secret meta = %q, want it masked", got["stripe_secret_key"])
+	}
+
+	all, err := dm.GetMetasFor(ctx, "workspace", []int64{withMetas}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all[withMetas]) != 3 {
+		t.Errorf("all metas = %v", all[withMetas])
+	}
+}
+
+func TestConcurrentIncrementsAddUp(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Counter")
+
+	const increments = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, increments)
+	for i := 0; i < increments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dm.IncrementMeta(ctx, "user", id, "logins", 1.5)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := dm.IncrementMeta(ctx, "user", id, "logins", 0)
+	if err != nil || value != increments*1.5 {
+		t.Errorf("got %v, %v, want %v", value, err, increments*1.5)
+	}
+	if _, err := dm.IncrementMeta(ctx, "workspace", id, "stripe_secret_key", 1); err == nil {
+		t.Error("incremented a secret meta")
+	}
+}
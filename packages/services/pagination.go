@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// Page sizes accepted by list endpoints
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ListCursor marks the last row of a page. It is handed to clients as an
+// opaque string and only valid for the sort order it was created with.
+type ListCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"i"`
+}
+
+// BlockPage is one page of a block list. NextCursor is empty on the last page
+// and Total is only set when ListOptions.WithTotal is.
+type BlockPage struct {
+	Blocks     []map[string]interface{}
+	NextCursor string
+	Total      *int
+}
+
+// Encode renders the cursor as an opaque URL-safe string
+func (c ListCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, NewValidationError("after", "is not a valid cursor")
+	}
+	var c ListCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID <= 0 {
+		return nil, NewValidationError("after", "is not a valid cursor")
+	}
+	return &c, nil
+}
+
+// parsePagination reads after, limit and total from a query string into opts
+func parsePagination(opts *ListOptions, after, limit, total string) *ValidationError {
+	verr := &ValidationError{}
+	opts.Limit = DefaultPageSize
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			verr.Add("limit", "must be a positive number")
+		} else if n > MaxPageSize {
+			opts.Limit = MaxPageSize
+		} else {
+			opts.Limit = n
+		}
+	}
+
+	if after != "" {
+		cursor, err := DecodeCursor(after)
+		switch {
+		case err != nil:
+			verr.Add("after", "is not a valid cursor")
+		case cursor.Sort != opts.sortKey() || cursor.Desc != opts.Desc:
+			verr.Add("after", "was created for a different sort order")
+		default:
+			opts.After = cursor
+		}
+	}
+
+	switch total {
+	case "", "false", "0":
+	case "true", "1":
+		opts.WithTotal = true
+	default:
+		verr.Add("total", "must be true or false")
+	}
+	return verr
+}
+
+// sortKey names the sort order a cursor belongs to
+func (o ListOptions) sortKey() string {
+	if o.Sort == "" {
+		return "id"
+	}
+	return o.Sort
+}
+
+// pageSize is the number of rows per page
+func (o ListOptions) pageSize() int {
+	if o.Limit < 1 {
+		return DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return o.Limit
+}
+
+// sortExpr is the SQL expression rows are ordered by, besides id. Metas
+// declared as numbers compare as numbers.
+func (o ListOptions) sortExpr(d database.Dialect, table string) (string, []interface{}) {
+	if o.MetaKey != "" {
+		value := fmt.Sprintf(
+			"(SELECT m.meta_value FROM metas m WHERE m.parent = %[1]s.type AND m.parent_id = %[1]s.id AND m.meta_key = ? AND m.status = 1)",
+			table,
+		)
+		if o.numeric {
+			return "COALESCE(" + d.CastDecimal(value) + ", 0)", []interface{}{o.MetaKey}
+		}
+		return "COALESCE(" + value + ", '')", []interface{}{o.MetaKey}
+	}
+	return table + "." + o.Sort, nil
+}
+
+// cursorValue is the sort value of the cursor as it is compared in SQL
+func (o ListOptions) cursorValue() interface{} {
+	if !o.numeric {
+		return o.After.Value
+	}
+	return numericSortValue(o.After.Value)
+}
+
+// numericSortValue reads a meta value the way a numeric sort orders it, with
+// missing and malformed values as zero
+func numericSortValue(value string) float64 {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// keyset renders the condition selecting the rows after opts.After
+func (o ListOptions) keyset(d database.Dialect, table string) (string, []interface{}) {
+	if o.After == nil {
+		return "", nil
+	}
+	compare := ">"
+	if o.Desc {
+		compare = "<"
+	}
+	if o.Sort == "" || o.Sort == "id" {
+		return fmt.Sprintf(" AND %s.id %s ?", table, compare), []interface{}{o.After.ID}
+	}
+
+	expr, exprArgs := o.sortExpr(d, table)
+	args := append(append([]interface{}{}, exprArgs...), o.cursorValue())
+	args = append(args, exprArgs...)
+	args = append(args, o.cursorValue(), o.After.ID)
+	return fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND %[3]s.id %[2]s ?))", expr, compare, table), args
+}
+
+// nextCursor builds the cursor following block in the order of opts
+func (o ListOptions) nextCursor(ctx context.Context, q queryer, b *Block) (string, error) {
+	c := ListCursor{Sort: o.sortKey(), Desc: o.Desc, ID: b.ID}
+	switch o.Sort {
+	case "", "id":
+	case "created_at":
+		c.Value = b.CreatedAt
+	case "modified_at":
+		c.Value = b.ModifiedAt
+	case "position":
+		c.Value = b.Position
+	case "title":
+		c.Value = b.Title
+	default:
+		// Cursors are handed to clients, so they must never carry a secret
+		if isSecretMeta(b.Type, o.MetaKey) {
+			return "", fmt.Errorf("cannot page by secret meta %s", o.MetaKey)
+		}
+		err := q.QueryRowContext(ctx,
+			"SELECT meta_value FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ? AND status = 1",
+			b.Type, b.ID, o.MetaKey,
+		).Scan(&c.Value)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if o.numeric {
+			c.Value = strconv.FormatFloat(numericSortValue(c.Value), 'f', -1, 64)
+		}
+	}
+	return c.Encode(), nil
+}
+
+// listBlocks runs a paginated list of blocks of blockType. from is the FROM and
+// WHERE part of the query over blocks aliased as table, without meta filters.
+func (dm *DatabaseManager) listBlocks(ctx context.Context, blockType, table, from string, fromArgs []interface{}, page int, opts ListOptions) (*BlockPage, error) {
+	if def, ok := GetBlockTypeDefinition(blockType); ok && opts.MetaKey != "" {
+		field, ok := def.GetMetaField(opts.MetaKey)
+		opts.numeric = ok && field.Type == MetaNumber
+	}
+	where, whereArgs := opts.where(dm.db.Dialect, table)
+	from += where
+	fromArgs = append(append([]interface{}{}, fromArgs...), whereArgs...)
+
+	result := &BlockPage{Blocks: []map[string]interface{}{}}
+	if opts.WithTotal {
+		var total int
+		if err := dm.q().QueryRowContext(ctx, "SELECT COUNT(*) "+from, fromArgs...).Scan(&total); err != nil {
+			return nil, err
+		}
+		result.Total = &total
+	}
+
+	keyset, keysetArgs := opts.keyset(dm.db.Dialect, table)
+	orderBy, orderArgs := opts.orderBy(dm.db.Dialect, table)
+	limit := opts.pageSize()
+	offset := 0
+	if opts.After == nil && page > 1 {
+		offset = (page - 1) * limit
+	}
+
+	args := append(fromArgs, keysetArgs...)
+	args = append(args, orderArgs...)
+	args = append(args, limit+1, offset)
+	columns := blockColumns
+	if table == "b" {
+		columns = qualifiedBlockColumns
+	}
+	rows, err := dm.q().QueryContext(ctx, "SELECT "+columns+" "+from+keyset+orderBy+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []*Block
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// One extra row was fetched to learn whether another page follows
+	if len(blocks) > limit {
+		blocks = blocks[:limit]
+		if result.NextCursor, err = opts.nextCursor(ctx, dm.q(), blocks[limit-1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range blocks {
+		result.Blocks = append(result.Blocks, BlockToMap(b))
+	}
+
+	if err := dm.attachMetas(ctx, result.Blocks, opts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestCursorsNeverCarrySecrets(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSecretKeys(t)
+	id := addWorkspace(t, dm, "Shop")
+	if err := dm.AddMeta(ctx, "workspace", id, "stripe_secret_key", "sk_live_AAAA1111secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ParseListOptions refuses this order; nextCursor must not rely on it
+	opts := ListOptions{Sort: "meta.stripe_secret_key", MetaKey: "stripe_secret_key"}
+	block, err := dm.FindBlock(ctx, "workspace", id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err := opts.nextCursor(ctx, db, block); err == nil {
+		t.Errorf("got cursor %q, want an error", cursor)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := ListCursor{Sort: "meta.stage", Desc: true, Value: "lead / 50%", ID: 42}
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil || *decoded != cursor {
+		t.Fatalf("decoded %+v, %v, want %+v", decoded, err, cursor)
+	}
+	for _, s := range []string{"", "not a cursor", ListCursor{Sort: "id"}.Encode()} {
+		if _, err := DecodeCursor(s); err == nil {
+			t.Errorf("DecodeCursor(%q) succeeded", s)
+		}
+	}
+
+	// A cursor is only accepted for the order it was created with
+	for query, ok := range map[string]bool{
+		"sort=meta.stage&order=desc": true,
+		"sort=meta.stage&order=asc":  false,
+		"sort=title&order=desc":      false,
+	} {
+		values, _ := url.ParseQuery(query)
+		values.Set("after", cursor.Encode())
+		opts, err := ParseListOptions(values)
+		if ok && (err != nil || opts.After == nil || *opts.After != cursor) {
+			t.Errorf("%s: got %+v, %v", query, opts.After, err)
+		}
+		if verr, isValidation := err.(*ValidationError); !ok && (!isValidation || verr.Fields["after"] == "") {
+			t.Errorf("%s: got %v, want an error on after", query, err)
+		}
+	}
+}
+
+func TestPagesBreakTiesByID(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byTitle := map[string][]int64{}
+	for _, title := range []string{"Beta", "Alpha", "Beta", "Alpha", "Beta"} {
+		id := addWorkspace(t, dm, title)
+		byTitle[title] = append(byTitle[title], id)
+		if title == "Alpha" {
+			if err := dm.AddMeta(ctx, "workspace", id, "prompt", "same"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	reversed := func(ids []int64) []int64 {
+		out := make([]int64, len(ids))
+		for i, id := range ids {
+			out[len(ids)-1-i] = id
+		}
+		return out
+	}
+	alpha, beta := byTitle["Alpha"], byTitle["Beta"]
+
+	for query, want := range map[string][]int64{
+		"sort=title&order=asc":        append(append([]int64{}, alpha...), beta...),
+		"sort=title&order=desc":       append(reversed(beta), reversed(alpha)...),
+		"sort=meta.prompt&order=asc":  append(append([]int64{}, beta...), alpha...),
+		"sort=meta.prompt&order=desc": append(reversed(alpha), reversed(beta)...),
+	} {
+		values, _ := url.ParseQuery(query + "&limit=2")
+		var got []int64
+		for pages := 0; pages < 10; pages++ {
+			opts, err := ParseListOptions(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := dm.GetBlocks(ctx, 1, "workspace", 1, 0, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, block := range page.Blocks {
+				got = append(got, block["id"].(int64))
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			values.Set("after", page.NextCursor)
+		}
+		if !sameIDs(got, want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+}
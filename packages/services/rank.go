@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Position keys are base-36 strings compared lexicographically. Keys never end
+// in the zero digit, so there is always room for a key between any two.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// maxRankLength is the key length past which siblings get renumbered
+const maxRankLength = 64
+
+var errRankOrder = errors.New("rank bounds are out of order")
+
+// RankBetween returns a key that sorts strictly between a and b. An empty a
+// means "before everything" and an empty b "after everything".
+func RankBetween(a, b string) (string, error) {
+	if b != "" && a >= b {
+		return "", errRankOrder
+	}
+	if strings.HasSuffix(a, "0") || strings.HasSuffix(b, "0") {
+		return "", errors.New("rank keys must not end in 0")
+	}
+	return rankMidpoint(a, b), nil
+}
+
+func rankMidpoint(a, b string) string {
+	if b != "" {
+		// Copy the common prefix, treating a missing digit of a as zero
+		n := 0
+		for n < len(b) {
+			digitA := byte('0')
+			if n < len(a) {
+				digitA = a[n]
+			}
+			if digitA != b[n] {
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + rankMidpoint(rest, b[n:])
+		}
+	}
+
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(rankDigits, a[0])
+	}
+	digitB := len(rankDigits)
+	if b != "" {
+		digitB = strings.IndexByte(rankDigits, b[0])
+	}
+
+	if digitB-digitA > 1 {
+		return string(rankDigits[(digitA+digitB+1)/2])
+	}
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(rankDigits[digitA]) + rankMidpoint(rest, "")
+}
+
+// rankAfter returns a short key that sorts after a, for appending to a list
+func rankAfter(a string) string {
+	if a == "" {
+		return "i"
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != 'z' {
+			return a[:i] + string(rankDigits[strings.IndexByte(rankDigits, a[i])+1])
+		}
+	}
+	return a + "i"
+}
+
+// evenRanks returns n increasing keys spread evenly over the key space
+func evenRanks(n int) []string {
+	width := 1
+	space := int64(len(rankDigits))
+	for space <= int64(n) && width < 10 {
+		width++
+		space *= int64(len(rankDigits))
+	}
+	step := space / int64(n+1)
+	if step < 1 {
+		step = 1
+	}
+
+	keys := make([]string, n)
+	for i := 0; i < n; i++ {
+		value := int64(i+1) * step
+		key := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			key[j] = rankDigits[value%int64(len(rankDigits))]
+			value /= int64(len(rankDigits))
+		}
+		keys[i] = strings.TrimRight(string(key), "0")
+	}
+	return keys
+}
+
+// nextPosition returns a key that places a new block after its siblings of the
+// same type
+func (dm *DatabaseManager) nextPosition(ctx context.Context, parent int64, blockType string) (string, error) {
+	return lastPosition(ctx, dm.q(), parent, blockType)
+}
+
+func lastPosition(ctx context.Context, q queryer, parent int64, blockType string) (string, error) {
+	var last sql.NullString
+	err := q.QueryRowContext(ctx, "SELECT MAX(position) FROM blocks WHERE parent = ? AND type = ?", parent, blockType).Scan(&last)
+	if err != nil {
+		return "", err
+	}
+
+	position := rankAfter(last.String)
+	if len(position) > maxRankLength {
+		if err := normalizePositions(ctx, q, parent, blockType); err != nil {
+			return "", err
+		}
+		return lastPosition(ctx, q, parent, blockType)
+	}
+	return position, nil
+}
+
+// normalizePositions renumbers the siblings of a type under parent with evenly
+// spaced keys, keeping their current order. Blocks without a position keep
+// their creation order.
+func normalizePositions(ctx context.Context, q queryer, parent int64, blockType string) error {
+	rows, err := q.QueryContext(ctx, "SELECT id FROM blocks WHERE parent = ? AND type = ? ORDER BY position, id", parent, blockType)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, key := range evenRanks(len(ids)) {
+		if _, err := q.ExecContext(ctx, "UPDATE blocks SET position = ? WHERE id = ?", key, ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RepositionBlock moves a block between two of its siblings. Either neighbour
+// may be 0 to place the block directly after afterID or directly before
+// beforeID. Only the moved block is updated unless its siblings need to be
+// renumbered first.
+func (dm *DatabaseManager) RepositionBlock(ctx context.Context, id, afterID, beforeID int64) (string, error) {
+	if afterID == 0 && beforeID == 0 {
+		return "", NewValidationError("after", "after or before is required")
+	}
+	if afterID == id || beforeID == id {
+		return "", NewValidationError("after", "a block cannot be placed next to itself")
+	}
+
+	block, err := dm.FindBlock(ctx, "", id, "")
+	if err != nil {
+		return "", err
+	}
+	if block == nil {
+		return "", sql.ErrNoRows
+	}
+	parent := int64(0)
+	if block.Parent != nil {
+		parent = *block.Parent
+	}
+
+	var position string
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		for attempt := 0; ; attempt++ {
+			a, b, err := neighbourPositions(ctx, tx, block, parent, afterID, beforeID)
+			if err != nil {
+				return err
+			}
+
+			// Legacy rows without a position, or keys that grew too long, are
+			// renumbered once before retrying
+			needsRoom := (afterID > 0 && a == "") || (beforeID > 0 && b == "")
+			if !needsRoom {
+				position, err = RankBetween(a, b)
+				if err == errRankOrder && afterID > 0 && beforeID > 0 && attempt > 0 {
+					return NewValidationError("before", "must come after the after block")
+				}
+				needsRoom = err != nil || len(position) > maxRankLength
+			}
+			if !needsRoom {
+				break
+			}
+			if attempt > 0 {
+				return errors.New("failed to find a position between the given blocks")
+			}
+			if err := normalizePositions(ctx, tx, parent, block.Type); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE blocks SET position = ? WHERE id = ?", position, id); err != nil {
+			return err
+		}
+		return tx.publishBlock(ctx, EventBlockUpdated, id)
+	})
+	if err != nil {
+		return "", err
+	}
+	return position, nil
+}
+
+// neighbourPositions returns the keys the block has to fit between. A missing
+// neighbour is filled in with the adjacent sibling on that side.
+func neighbourPositions(ctx context.Context, q queryer, block *Block, parent, afterID, beforeID int64) (string, string, error) {
+	sibling := func(siblingID int64, field string) (string, error) {
+		var position string
+		err := q.QueryRowContext(ctx,
+			"SELECT position FROM blocks WHERE id = ? AND parent = ? AND type = ? AND status = 1",
+			siblingID, parent, block.Type,
+		).Scan(&position)
+		if err == sql.ErrNoRows {
+			return "", NewValidationError(field, "must be an active sibling of the same type")
+		}
+		return position, err
+	}
+	adjacent := func(query string, args ...interface{}) (string, error) {
+		var position sql.NullString
+		err := q.QueryRowContext(ctx, query, args...).Scan(&position)
+		return position.String, err
+	}
+
+	var a, b string
+	var err error
+	if afterID > 0 {
+		if a, err = sibling(afterID, "after"); err != nil {
+			return "", "", err
+		}
+	}
+	if beforeID > 0 {
+		if b, err = sibling(beforeID, "before"); err != nil {
+			return "", "", err
+		}
+	}
+
+	if afterID > 0 && beforeID == 0 && a != "" {
+		b, err = adjacent(
+			"SELECT MIN(position) FROM blocks WHERE parent = ? AND type = ? AND position > ? AND id <> ?",
+			parent, block.Type, a, block.ID,
+		)
+	} else if beforeID > 0 && afterID == 0 && b != "" {
+		a, err = adjacent(
+			"SELECT MAX(position) FROM blocks WHERE parent = ? AND type = ? AND position < ? AND position <> '' AND id <> ?",
+			parent, block.Type, b, block.ID,
+		)
+	}
+	return a, b, err
+}
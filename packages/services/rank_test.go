@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRankBetweenRepeatedInsertion(t *testing.T) {
+	// Keep inserting right after a, and right before b, between the same
+	// two neighbours
+	for _, side := range []string{"after a", "before b"} {
+		a, b := "a", "b"
+		for i := 0; i < 500; i++ {
+			key, err := RankBetween(a, b)
+			if err != nil {
+				t.Fatalf("%s, insertion %d between %q and %q: %v", side, i, a, b, err)
+			}
+			if key <= a || key >= b || strings.HasSuffix(key, "0") {
+				t.Fatalf("%s, insertion %d: %q is not a key between %q and %q", side, i, key, a, b)
+			}
+			if side == "after a" {
+				b = key
+			} else {
+				a = key
+			}
+		}
+	}
+
+	for _, tt := range []struct{ a, b string }{{"", ""}, {"", "1"}, {"z", ""}, {"zz", ""}, {"a", "a1"}, {"a01", "a1"}} {
+		key, err := RankBetween(tt.a, tt.b)
+		if err != nil || key <= tt.a || (tt.b != "" && key >= tt.b) {
+			t.Errorf("RankBetween(%q, %q) = %q, %v", tt.a, tt.b, key, err)
+		}
+	}
+	for _, tt := range []struct{ a, b string }{{"b", "a"}, {"a", "a"}, {"a0", "b"}} {
+		if key, err := RankBetween(tt.a, tt.b); err == nil {
+			t.Errorf("RankBetween(%q, %q) = %q, want an error", tt.a, tt.b, key)
+		}
+	}
+}
+
+func TestRepositionRenumbersLongKeys(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := addWorkspace(t, dm, "Board")
+	var ids []int64
+	for _, title := range []string{"First", "Second", "Third"} {
+		block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "thread", "title": title, "content": "", "parent": workspace}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, block["id"].(int64))
+	}
+	first := ids[0]
+
+	// Moving the last thread right after the first halves the gap each time,
+	// so keys grow until the threads are renumbered
+	longest, renumbered := 0, false
+	for i := 0; i < 400; i++ {
+		moved := ids[2]
+		position, err := dm.RepositionBlock(ctx, moved, first, 0)
+		if err != nil {
+			t.Fatalf("move %d: %v", i, err)
+		}
+		if len(position) > maxRankLength {
+			t.Fatalf("move %d: key of %d digits", i, len(position))
+		}
+		if len(position) < longest {
+			renumbered = true
+		}
+		if len(position) > longest {
+			longest = len(position)
+		}
+		ids = []int64{first, moved, ids[1]}
+	}
+	if !renumbered {
+		t.Errorf("keys grew to %d digits without being renumbered", longest)
+	}
+
+	rows, err := db.Query("SELECT id FROM blocks WHERE parent = ? AND type = 'thread' ORDER BY position", workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if !sameIDs(got, ids) {
+		t.Errorf("order = %v, want %v", got, ids)
+	}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// RetrievalWeightsMetaKey is the workspace meta holding its RetrievalWeights
+const RetrievalWeightsMetaKey = "retrieval_weights"
+
+// maxRetrievalLimit bounds the number of chunks returned by Retrieve
+const maxRetrievalLimit = 100
+
+// RetrievalWeights tunes reciprocal rank fusion. A chunk scores
+// Keyword/(K+keyword rank) + Vector/(K+vector rank); a zero weight turns that
+// component off.
+type RetrievalWeights struct {
+	Keyword float64 `json:"keyword"`
+	Vector  float64 `json:"vector"`
+	K       float64 `json:"k"`
+}
+
+// DefaultRetrievalWeights weighs both components equally with the usual k=60
+var DefaultRetrievalWeights = RetrievalWeights{Keyword: 1, Vector: 1, K: 60}
+
+// Validate checks the weights are usable
+func (w RetrievalWeights) Validate() error {
+	verr := &ValidationError{}
+	if w.Keyword < 0 || math.IsNaN(w.Keyword) || math.IsInf(w.Keyword, 0) {
+		verr.Add("keyword", "must be a non-negative number")
+	}
+	if w.Vector < 0 || math.IsNaN(w.Vector) || math.IsInf(w.Vector, 0) {
+		verr.Add("vector", "must be a non-negative number")
+	}
+	if w.Keyword == 0 && w.Vector == 0 {
+		verr.Add("keyword", "keyword and vector cannot both be zero")
+	}
+	if w.K < 1 || math.IsInf(w.K, 0) {
+		verr.Add("k", "must be at least 1")
+	}
+	return verr.OrNil()
+}
+
+// RetrievalResult is one retrieved chunk with the scores that ranked it. Ranks
+// are 1-based; 0 means the component did not match the chunk.
+type RetrievalResult struct {
+	Chunk        map[string]interface{} `json:"chunk"`
+	Score        float64                `json:"score"`
+	KeywordScore float64                `json:"keyword_score"`
+	KeywordRank  int                    `json:"keyword_rank"`
+	VectorScore  float64                `json:"vector_score"`
+	VectorRank   int                    `json:"vector_rank"`
+}
+
+// GetRetrievalWeights returns a workspace's weights, or the defaults when
+// none are stored
+func (dm *DatabaseManager) GetRetrievalWeights(ctx context.Context, workspaceID int64) (RetrievalWeights, error) {
+	weights, found, err := GetMetaJSON[RetrievalWeights](ctx, dm, "workspace", workspaceID, RetrievalWeightsMetaKey)
+	if err != nil || !found || weights.Validate() != nil {
+		return DefaultRetrievalWeights, err
+	}
+	return weights, nil
+}
+
+// SetRetrievalWeights validates and stores a workspace's weights
+func (dm *DatabaseManager) SetRetrievalWeights(ctx context.Context, workspaceID int64, weights RetrievalWeights) error {
+	if err := weights.Validate(); err != nil {
+		return err
+	}
+	return dm.SetMetaJSON(ctx, "workspace", workspaceID, RetrievalWeightsMetaKey, weights)
+}
+
+// Retrieve ranks the chunks below a workspace against a query by fusing BM25
+// keyword ranks with embedding similarity ranks. When embedding is nil and an
+// embedder is configured the query text is embedded; without either, only the
+// keyword component is used.
+func (dm *DatabaseManager) Retrieve(ctx context.Context, workspaceID int64, text string, embedding []float64, limit int) ([]RetrievalResult, RetrievalWeights, error) {
+	weights, err := dm.GetRetrievalWeights(ctx, workspaceID)
+	if err != nil {
+		return nil, weights, err
+	}
+	if limit < 1 || limit > maxRetrievalLimit {
+		limit = 10
+	}
+	if len(searchTerms(text)) == 0 && len(embedding) == 0 {
+		return nil, weights, NewValidationError("query", "is required")
+	}
+	if embedding == nil && weights.Vector > 0 && text != "" {
+		if e := currentEmbedder(); e != nil {
+			if embedding, err = e.Embed(ctx, text); err != nil {
+				return nil, weights, err
+			}
+		}
+	}
+
+	chunks, err := dm.workspaceChunks(ctx, workspaceID)
+	if err != nil {
+		return nil, weights, err
+	}
+	results := make(map[int64]*RetrievalResult, len(chunks))
+
+	if weights.Keyword > 0 {
+		idx := NewMemoryIndex()
+		for _, c := range chunks {
+			idx.Add(SearchDocument{Block: c.block, Ancestors: []int64{workspaceID}})
+		}
+		idx.mu.RLock()
+		ids, scores := idx.rank(searchTerms(text), SearchQuery{RootIDs: []int64{workspaceID}})
+		idx.mu.RUnlock()
+		for i, id := range ids {
+			r := retrievalResult(results, id)
+			r.KeywordScore = scores[id]
+			r.KeywordRank = i + 1
+		}
+	}
+
+	if weights.Vector > 0 && len(embedding) > 0 {
+		var ids []int64
+		similarity := map[int64]float64{}
+		for id, c := range chunks {
+			if len(c.embedding) != len(embedding) {
+				continue
+			}
+			similarity[id] = CosineSimilarity(embedding, c.embedding)
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			if similarity[ids[i]] != similarity[ids[j]] {
+				return similarity[ids[i]] > similarity[ids[j]]
+			}
+			return ids[i] > ids[j]
+		})
+		for i, id := range ids {
+			r := retrievalResult(results, id)
+			r.VectorScore = similarity[id]
+			r.VectorRank = i + 1
+		}
+	}
+
+	ranked := make([]RetrievalResult, 0, len(results))
+	for id, r := range results {
+		if r.KeywordRank > 0 {
+			r.Score += weights.Keyword / (weights.K + float64(r.KeywordRank))
+		}
+		if r.VectorRank > 0 {
+			r.Score += weights.Vector / (weights.K + float64(r.VectorRank))
+		}
+		r.Chunk = BlockToMap(chunks[id].block)
+		ranked = append(ranked, *r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Chunk["id"].(int64) > ranked[j].Chunk["id"].(int64)
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, weights, nil
+}
+
+func retrievalResult(results map[int64]*RetrievalResult, id int64) *RetrievalResult {
+	r, ok := results[id]
+	if !ok {
+		r = &RetrievalResult{}
+		results[id] = r
+	}
+	return r
+}
+
+type retrievalChunk struct {
+	block     *Block
+	embedding []float64
+}
+
+// workspaceChunks loads the active chunks below a workspace with their
+// embeddings
+func (dm *DatabaseManager) workspaceChunks(ctx context.Context, workspaceID int64) (map[int64]*retrievalChunk, error) {
+	cte, args := descendantsCTE(workspaceID, maxBlockDepth, []int{StatusActive}, nil)
+	rows, err := dm.q().QueryContext(ctx,
+		cte+"SELECT "+qualifiedBlockColumns+" FROM tree t INNER JOIN blocks b ON b.id = t.id WHERE b.type = ?",
+		append(args, "chunk")...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	chunks := map[int64]*retrievalChunk{}
+	var ids []int64
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chunks[b.ID] = &retrievalChunk{block: b}
+		ids = append(ids, b.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	metas, err := dm.GetMetasFor(ctx, "chunk", ids, []string{"embedding"})
+	if err != nil {
+		return nil, err
+	}
+	for id, m := range metas {
+		if raw := m["embedding"]; raw != "" {
+			// a malformed embedding leaves the chunk to keyword matching
+			_ = json.Unmarshal([]byte(raw), &chunks[id].embedding)
+		}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+type fakeEmbedder struct {
+	vector []float64
+	calls  int
+}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.calls++
+	return e.vector, nil
+}
+
+// addChunks creates a knowledge block in a new workspace with one chunk per
+// content, each with the matching embedding, and returns the workspace and
+// the chunk ids
+func addChunks(t *testing.T, dm *DatabaseManager, contents []string, embeddings [][]float64) (int64, []int64) {
+	t.Helper()
+	ctx := context.Background()
+	workspace := addWorkspace(t, dm, "Docs")
+	knowledge, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "knowledge", "title": "Manual", "content": "", "parent": workspace}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, len(contents))
+	for i, content := range contents {
+		chunk, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "chunk", "title": "", "content": content, "parent": knowledge["id"]}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = chunk["id"].(int64)
+		if err := dm.SetMetaJSON(ctx, "chunk", ids[i], "embedding", embeddings[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workspace, ids
+}
+
+func resultIDs(results []RetrievalResult) []int64 {
+	ids := make([]int64, len(results))
+	for i, r := range results {
+		ids[i] = r.Chunk["id"].(int64)
+	}
+	return ids
+}
+
+func TestRetrieveFusesRanks(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keyword ranks: keyword, both; vector ranks: both, vector, keyword
+	workspace, ids := addChunks(t, dm,
+		[]string{"walrus walrus walrus", "a walrus among seals on the ice", "seals on the ice"},
+		[][]float64{{0, 1}, {1, 0.1}, {1, 0.5}},
+	)
+	keyword, both, vector := ids[0], ids[1], ids[2]
+
+	results, weights, err := dm.Retrieve(ctx, workspace, "walrus", []float64{1, 0}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weights != DefaultRetrievalWeights {
+		t.Errorf("weights = %+v, want the defaults", weights)
+	}
+	if got := resultIDs(results); !sameIDs(got, []int64{both, keyword, vector}) {
+		t.Fatalf("order = %v, want %v", got, []int64{both, keyword, vector})
+	}
+	ranks := [][2]int{{2, 1}, {1, 3}, {0, 2}}
+	for i, r := range results {
+		if r.KeywordRank != ranks[i][0] || r.VectorRank != ranks[i][1] {
+			t.Errorf("%d: ranks %d and %d, want %v", r.Chunk["id"], r.KeywordRank, r.VectorRank, ranks[i])
+		}
+		want := 0.0
+		if r.KeywordRank > 0 {
+			want += 1 / (60 + float64(r.KeywordRank))
+		}
+		want += 1 / (60 + float64(r.VectorRank))
+		if math.Abs(r.Score-want) > 1e-12 {
+			t.Errorf("%d: score %v, want %v", r.Chunk["id"], r.Score, want)
+		}
+	}
+
+	// A workspace that only weighs keywords ignores the embeddings
+	if err := dm.SetRetrievalWeights(ctx, workspace, RetrievalWeights{Keyword: 1, K: 10}); err != nil {
+		t.Fatal(err)
+	}
+	results, _, err = dm.Retrieve(ctx, workspace, "walrus", []float64{1, 0}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(results); !sameIDs(got, []int64{keyword, both}) || results[0].Score != 1.0/11 {
+		t.Errorf("keyword only: got %v scoring %v", got, results[0].Score)
+	}
+
+	results, _, err = dm.Retrieve(ctx, workspace, "walrus", nil, 1)
+	if err != nil || len(results) != 1 {
+		t.Errorf("limit 1: got %d results, %v", len(results), err)
+	}
+}
+
+func TestRetrieveFallsBackToKeywordsWithoutAnEmbedder(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetEmbedder(nil) })
+
+	workspace, ids := addChunks(t, dm,
+		[]string{"walrus facts", "seals on the ice"},
+		[][]float64{{0, 1}, {1, 0}},
+	)
+
+	SetEmbedder(nil)
+	results, _, err := dm.Retrieve(ctx, workspace, "walrus", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(results); !sameIDs(got, []int64{ids[0]}) || results[0].VectorRank != 0 {
+		t.Errorf("without an embedder: got %v, want only the keyword match", got)
+	}
+
+	embedder := &fakeEmbedder{vector: []float64{1, 0}}
+	SetEmbedder(embedder)
+	results, _, err = dm.Retrieve(ctx, workspace, "walrus", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if embedder.calls != 1 || len(results) != 2 || results[0].VectorRank == 0 {
+		t.Errorf("with an embedder: %d calls, got %+v", embedder.calls, results)
+	}
+
+	// A query embedding passed in is used as is
+	if _, _, err := dm.Retrieve(ctx, workspace, "walrus", []float64{0, 1}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if embedder.calls != 1 {
+		t.Errorf("the embedder was called for a query that had an embedding")
+	}
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// defaultRevisionLimit is how many revisions are kept per block unless the
+// block type says otherwise
+const defaultRevisionLimit = 50
+
+// FieldChange is one changed value in a revision diff; nil means absent
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// RevisionDiff lists what changed relative to the previous revision
+type RevisionDiff struct {
+	Title   *FieldChange           `json:"title,omitempty"`
+	Content *FieldChange           `json:"content,omitempty"`
+	Metas   map[string]FieldChange `json:"metas,omitempty"`
+}
+
+// IsEmpty reports whether the diff records no change
+func (d RevisionDiff) IsEmpty() bool {
+	return d.Title == nil && d.Content == nil && len(d.Metas) == 0
+}
+
+// Revision is a snapshot of a block's title, content and metas after a write
+type Revision struct {
+	ID        int64             `json:"id"`
+	BlockID   int64             `json:"block_id"`
+	Revision  int               `json:"revision"`
+	Author    int64             `json:"author"`
+	Title     string            `json:"title"`
+	Content   string            `json:"content,omitempty"`
+	Metas     map[string]string `json:"metas,omitempty"`
+	Diff      RevisionDiff      `json:"diff"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// revisionLimit returns how many revisions to keep, or a negative number when
+// the type does not keep history
+func (d BlockTypeDefinition) revisionLimit() int {
+	if d.Revisions == 0 {
+		return defaultRevisionLimit
+	}
+	return d.Revisions
+}
+
+// revisionMetas returns the metas of a block that are tracked in its history.
+// Privilege and secret metas are left out.
+func (dm *DatabaseManager) revisionMetas(ctx context.Context, block *Block) (map[string]string, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT meta_key, meta_value FROM metas WHERE parent = ? AND parent_id = ? AND status = 1",
+		block.Type, block.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metas := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(key, "privilege_") || isSecretMeta(block.Type, key) {
+			continue
+		}
+		metas[key] = value
+	}
+	return metas, rows.Err()
+}
+
+func diffRevision(previous *Revision, block *Block, metas map[string]string) RevisionDiff {
+	diff := RevisionDiff{}
+	prevTitle, prevContent, prevMetas := "", "", map[string]string{}
+	if previous != nil {
+		prevTitle, prevContent, prevMetas = previous.Title, previous.Content, previous.Metas
+	}
+
+	if prevTitle != block.Title {
+		diff.Title = &FieldChange{From: prevTitle, To: block.Title}
+	}
+	if prevContent != block.Content {
+		diff.Content = &FieldChange{From: prevContent, To: block.Content}
+	}
+
+	changes := map[string]FieldChange{}
+	for key, value := range metas {
+		if old, ok := prevMetas[key]; !ok {
+			changes[key] = FieldChange{From: nil, To: value}
+		} else if old != value {
+			changes[key] = FieldChange{From: old, To: value}
+		}
+	}
+	for key, old := range prevMetas {
+		if _, ok := metas[key]; !ok {
+			changes[key] = FieldChange{From: old, To: nil}
+		}
+	}
+	if len(changes) > 0 {
+		diff.Metas = changes
+	}
+	return diff
+}
+
+// lockRevisions takes the row lock of a block until the transaction ends and
+// records its current state as the first revision when it has no history yet,
+// so the first edit of an older block can be undone. Concurrent writes to the
+// block wait for the lock, so each numbers its revision after the last one.
+func (tx *Tx) lockRevisions(ctx context.Context, blockID int64) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE blocks SET version = version WHERE id = ?", blockID); err != nil {
+		return err
+	}
+	return tx.ensureRevision(ctx, blockID)
+}
+
+func (dm *DatabaseManager) ensureRevision(ctx context.Context, blockID int64) error {
+	var count int
+	if err := dm.q().QueryRowContext(ctx, "SELECT COUNT(*) FROM block_revisions WHERE block_id = ?", blockID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	block, err := dm.FindBlock(ctx, "", blockID, "")
+	if err != nil || block == nil {
+		return err
+	}
+	return dm.recordRevision(ctx, blockID, int64(block.Author))
+}
+
+// recordRevision snapshots a block after a write and prunes old revisions
+// beyond the retention of its type. Nothing is stored when nothing changed.
+// It runs in the transaction of the write, after lockRevisions.
+func (dm *DatabaseManager) recordRevision(ctx context.Context, blockID int64, author int64) error {
+	block, err := dm.FindBlock(ctx, "", blockID, "")
+	if err != nil || block == nil {
+		return err
+	}
+
+	def, ok := GetBlockTypeDefinition(block.Type)
+	if !ok || def.revisionLimit() < 0 {
+		return nil
+	}
+
+	metas, err := dm.revisionMetas(ctx, block)
+	if err != nil {
+		return err
+	}
+
+	previous, err := dm.latestRevision(ctx, blockID)
+	if err != nil {
+		return err
+	}
+	diff := diffRevision(previous, block, metas)
+	if previous != nil && diff.IsEmpty() {
+		return nil
+	}
+
+	number := 1
+	if previous != nil {
+		number = previous.Revision + 1
+	}
+
+	metasJSON, err := json.Marshal(metas)
+	if err != nil {
+		return err
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+
+	_, err = dm.q().ExecContext(ctx,
+		"INSERT INTO block_revisions (block_id, revision, author, title, content, metas, diff, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		blockID, number, author, block.Title, block.Content, string(metasJSON), string(diffJSON),
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = dm.q().ExecContext(ctx,
+		"DELETE FROM block_revisions WHERE block_id = ? AND revision <= ?",
+		blockID, number-def.revisionLimit(),
+	)
+	return err
+}
+
+const revisionColumns = "id, block_id, revision, author, title, content, metas, diff, created_at"
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (*Revision, error) {
+	var r Revision
+	var metasJSON, diffJSON string
+	err := row.Scan(&r.ID, &r.BlockID, &r.Revision, &r.Author, &r.Title, &r.Content, &metasJSON, &diffJSON, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Metas = map[string]string{}
+	if metasJSON != "" {
+		if err := json.Unmarshal([]byte(metasJSON), &r.Metas); err != nil {
+			return nil, err
+		}
+	}
+	if diffJSON != "" {
+		if err := json.Unmarshal([]byte(diffJSON), &r.Diff); err != nil {
+			return nil, err
+		}
+	}
+	r.CreatedAt = FormatTimeToISO(r.CreatedAt)
+	return &r, nil
+}
+
+func (dm *DatabaseManager) latestRevision(ctx context.Context, blockID int64) (*Revision, error) {
+	r, err := scanRevision(dm.q().QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM block_revisions WHERE block_id = ? ORDER BY revision DESC LIMIT 1",
+		blockID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// GetRevisions lists the history of a block, newest first. Snapshots are left
+// out; fetch a single revision with GetRevision to see them.
+func (dm *DatabaseManager) GetRevisions(ctx context.Context, blockID int64) ([]Revision, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT "+revisionColumns+" FROM block_revisions WHERE block_id = ? ORDER BY revision DESC",
+		blockID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		r.Content = ""
+		r.Metas = nil
+		revisions = append(revisions, *r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetRevision fetches one revision of a block, or nil if it does not exist
+func (dm *DatabaseManager) GetRevision(ctx context.Context, blockID int64, revision int) (*Revision, error) {
+	r, err := scanRevision(dm.q().QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM block_revisions WHERE block_id = ? AND revision = ?",
+		blockID, revision,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// RestoreRevision puts a block's title, content and metas back to a stored
+// revision. The restore itself is recorded as a new revision.
+func (dm *DatabaseManager) RestoreRevision(ctx context.Context, userID int64, blockID int64, revision int) (map[string]interface{}, error) {
+	rev, err := dm.GetRevision(ctx, blockID, revision)
+	if err != nil || rev == nil {
+		return nil, err
+	}
+
+	metas := make(map[string]interface{}, len(rev.Metas))
+	for key, value := range rev.Metas {
+		metas[key] = value
+	}
+
+	var restored map[string]interface{}
+	err = dm.WithTx(ctx, func(tx *Tx) error {
+		// Read the block and the metas to drop under the lock so a meta added
+		// meanwhile is dropped too
+		if err := tx.lockRevisions(ctx, blockID); err != nil {
+			return err
+		}
+		block, err := tx.FindBlock(ctx, "", blockID, "")
+		if err != nil || block == nil {
+			return err
+		}
+		current, err := tx.revisionMetas(ctx, block)
+		if err != nil {
+			return err
+		}
+		for key := range current {
+			if _, ok := rev.Metas[key]; ok {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE metas SET status = 0 WHERE parent = ? AND parent_id = ? AND meta_key = ?",
+				block.Type, block.ID, key,
+			); err != nil {
+				return err
+			}
+		}
+
+		restored, err = tx.AddBlock(ctx, userID, map[string]interface{}{
+			"type":    block.Type,
+			"title":   rev.Title,
+			"content": rev.Content,
+			"metas":   metas,
+		}, block.Slug)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentEditsNumberRevisionsInOrder(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "workspace", "title": "Docs", "content": ""}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := block["id"].(int64)
+
+	const edits = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, edits)
+	for i := 0; i < edits; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- dm.AddMeta(ctx, "workspace", id, "description", fmt.Sprintf("edit %d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := dm.GetRevisions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != edits+1 {
+		t.Fatalf("got %d revisions, want %d", len(revisions), edits+1)
+	}
+	for i, r := range revisions {
+		if want := edits + 1 - i; r.Revision != want {
+			t.Errorf("revision %d is numbered %d, want %d", i, r.Revision, want)
+		}
+	}
+}
+
+func TestFailedEditRecordsNoRevision(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "workspace", "title": "Docs", "content": ""}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slug := block["slug"].(string)
+
+	_, err = dm.AddBlock(ctx, 1, map[string]interface{}{"type": "workspace", "title": "Renamed", "content": "", "version": int64(99)}, slug)
+	if err != ErrVersionConflict {
+		t.Fatalf("stale update returned %v, want ErrVersionConflict", err)
+	}
+
+	revisions, err := dm.GetRevisions(ctx, block["id"].(int64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Title != "Docs" {
+		t.Errorf("revisions after a rejected edit = %+v, want only the original", revisions)
+	}
+}
+
+func TestRestoreRevisionDropsLaterMetas(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := addWorkspace(t, dm, "Docs")
+	if err := dm.AddMeta(ctx, "workspace", id, "description", "first"); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := dm.GetRevisions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision := revisions[0].Revision
+
+	if err := dm.AddMeta(ctx, "workspace", id, "description", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.AddMeta(ctx, "workspace", id, "prompt", "Answer briefly"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dm.RestoreRevision(ctx, 1, id, revision); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := dm.GetMetas(ctx, id, "workspace", []string{"description", "prompt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metas["prompt"]; ok || metas["description"] != "first" {
+		t.Errorf("metas after restoring revision %d = %v, want only the first description", revision, metas)
+	}
+}
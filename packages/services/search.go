@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MaxSearchPerPage bounds the page size of search results
+const MaxSearchPerPage = 100
+
+// SearchQuery describes a full-text search. RootIDs limits results to the
+// subtrees of those top-level blocks; DatabaseManager.Search fills it with the
+// blocks the caller may read.
+type SearchQuery struct {
+	Text    string
+	Types   []string
+	Parent  int64 // only search below this block
+	RootIDs []int64
+	Page    int
+	PerPage int
+}
+
+// SearchHit is one ranked result. Highlights maps field names ("title",
+// "content" or "metas.<key>") to HTML-escaped snippets with matches in <mark>.
+type SearchHit struct {
+	Block      map[string]interface{} `json:"block"`
+	Score      float64                `json:"score"`
+	Highlights map[string]string      `json:"highlights"`
+}
+
+// SearchResults is a page of hits and the total number of matches
+type SearchResults struct {
+	Hits  []SearchHit `json:"results"`
+	Total int         `json:"total"`
+}
+
+// SearchBackend runs full-text queries
+type SearchBackend interface {
+	Search(ctx context.Context, q SearchQuery) (*SearchResults, error)
+}
+
+var (
+	searchBackendMu sync.RWMutex
+	searchBackend   SearchBackend
+)
+
+// SetSearchBackend replaces the backend used by DatabaseManager.Search. When
+// none is set, the full-text search of the manager's database is used.
+func SetSearchBackend(backend SearchBackend) {
+	searchBackendMu.Lock()
+	defer searchBackendMu.Unlock()
+	searchBackend = backend
+}
+
+// searchableMeta is a meta key a block type declares searchable
+type searchableMeta struct {
+	parent string
+	key    string
+}
+
+// searchableMetas lists the searchable metas of every block type. Keys are
+// per type, so a secret meta is never searched because another type has a
+// searchable meta of the same name.
+func searchableMetas() []searchableMeta {
+	var metas []searchableMeta
+	for _, def := range BlockTypeDefinitions() {
+		for _, f := range def.Metas {
+			if f.Searchable && !f.Secret {
+				metas = append(metas, searchableMeta{parent: def.Name, key: f.Name})
+			}
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		if metas[i].parent != metas[j].parent {
+			return metas[i].parent < metas[j].parent
+		}
+		return metas[i].key < metas[j].key
+	})
+	return metas
+}
+
+// isSearchableMeta reports whether a block type declares the meta searchable
+func isSearchableMeta(parent, key string) bool {
+	def, ok := GetBlockTypeDefinition(parent)
+	if !ok {
+		return false
+	}
+	field, ok := def.GetMetaField(key)
+	return ok && field.Searchable && !field.Secret
+}
+
+// searchableMetaCondition matches metas aliased as m against a list of
+// searchable metas
+func searchableMetaCondition(metas []searchableMeta) (string, []interface{}) {
+	conditions := make([]string, len(metas))
+	args := make([]interface{}, 0, 2*len(metas))
+	for i, m := range metas {
+		conditions[i] = "(m.parent = ? AND m.meta_key = ?)"
+		args = append(args, m.parent, m.key)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// loadSearchableMetas reads the searchable metas of active blocks, of every
+// block when ids is empty. Secret metas are left out even when sealed values
+// could not tell them apart.
+func loadSearchableMetas(ctx context.Context, q queryer, ids []int64) (map[int64]map[string]string, error) {
+	result := map[int64]map[string]string{}
+	metas := searchableMetas()
+	if len(metas) == 0 {
+		return result, nil
+	}
+
+	condition, args := searchableMetaCondition(metas)
+	query := "SELECT m.parent, m.parent_id, m.meta_key, m.meta_value FROM metas m INNER JOIN blocks b ON b.id = m.parent_id AND b.type = m.parent WHERE m.status = 1 AND b.status = 1 AND " + condition
+	if len(ids) > 0 {
+		query += " AND m.parent_id IN (" + placeholders(len(ids)) + ")"
+		args = append(args, int64Args(ids)...)
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parent, key, value string
+		var id int64
+		if err := rows.Scan(&parent, &id, &key, &value); err != nil {
+			return nil, err
+		}
+		if isSecretMeta(parent, key) || isSealedSecret(value) {
+			continue
+		}
+		if result[id] == nil {
+			result[id] = map[string]string{}
+		}
+		result[id][key] = value
+	}
+	return result, rows.Err()
+}
+
+// Search runs a full-text query over the blocks the current user may read
+func (dm *DatabaseManager) Search(ctx context.Context, q SearchQuery) (*SearchResults, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return nil, NewValidationError("q", "is required")
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PerPage < 1 {
+		q.PerPage = 20
+	}
+	if q.PerPage > MaxSearchPerPage {
+		q.PerPage = MaxSearchPerPage
+	}
+
+	roots, err := dm.readableRoots(ctx, dm.userID)
+	if err != nil {
+		return nil, err
+	}
+	if q.Parent > 0 {
+		parent, err := dm.FindBlock(ctx, "", q.Parent, "")
+		if err != nil {
+			return nil, err
+		}
+		var root *Block
+		if parent != nil {
+			if root, err = dm.GetRootBlock(ctx, q.Parent); err != nil {
+				return nil, err
+			}
+		}
+		if root == nil || !containsID(roots, root.ID) {
+			return &SearchResults{Hits: []SearchHit{}}, nil
+		}
+	}
+	q.RootIDs = roots
+	if len(roots) == 0 {
+		return &SearchResults{Hits: []SearchHit{}}, nil
+	}
+
+	searchBackendMu.RLock()
+	backend := searchBackend
+	searchBackendMu.RUnlock()
+	if backend == nil {
+		backend = NewSQLSearch(dm.db)
+	}
+	return backend.Search(ctx, q)
+}
+
+// readableRoots lists the active top-level blocks a user authored or holds a
+// privilege on
+func (dm *DatabaseManager) readableRoots(ctx context.Context, userID int64) ([]int64, error) {
+	if userID == 0 {
+		return nil, nil
+	}
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT id FROM blocks WHERE parent = 0 AND status = 1 AND ( author = ? OR id IN ( SELECT parent_id FROM metas WHERE parent = blocks.type AND meta_key = ? ) )",
+		userID, "privilege_"+strconv.FormatInt(userID, 10),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTerms splits text into lowercase letter and digit runs. Codes that
+// join such runs with "-", ".", "/" or "_" and contain a digit, like product
+// codes "XR-200" or "v2.1", are also kept whole so they match exactly.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r) && !strings.ContainsRune(codeJoiners, r)
+	}) {
+		word = strings.Trim(word, codeJoiners)
+		parts := strings.FieldsFunc(word, func(r rune) bool { return !isWordRune(r) })
+		terms = append(terms, parts...)
+		if len(parts) > 1 && strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// codeJoiners are the characters allowed inside codes kept by searchTerms
+const codeJoiners = "-./_"
+
+// snippetRadius is how many characters of context surround the first match
+const snippetRadius = 80
+
+// highlight returns an HTML-escaped snippet of text around the first matching
+// term with every match wrapped in <mark>, or "" when nothing matches
+func highlight(text string, terms []string) string {
+	if len(terms) == 0 || text == "" {
+		return ""
+	}
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// lowercasing changed the length; fall back to matching on the original
+		lower = runes
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < len(lower); {
+		if i > 0 && isWordRune(lower[i-1]) {
+			i++
+			continue
+		}
+		matched := 0
+		for _, term := range terms {
+			t := []rune(term)
+			if len(t) > matched && i+len(t) <= len(lower) && string(lower[i:i+len(t)]) == term {
+				matched = len(t)
+			}
+		}
+		if matched > 0 {
+			spans = append(spans, span{i, i + matched})
+			i += matched
+			continue
+		}
+		i++
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+
+	from := spans[0].start - snippetRadius
+	if from < 0 {
+		from = 0
+	}
+	to := spans[0].end + snippetRadius
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < from || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:s.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[s.start:s.end])))
+		b.WriteString("</mark>")
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchHighlights builds highlights for a block's title, content and metas
+func searchHighlights(block *Block, metas map[string]string, terms []string) map[string]string {
+	highlights := map[string]string{}
+	if s := highlight(block.Title, terms); s != "" {
+		highlights["title"] = s
+	}
+	if s := highlight(block.Content, terms); s != "" {
+		highlights["content"] = s
+	}
+	for key, value := range metas {
+		if s := highlight(value, terms); s != "" {
+			highlights["metas."+key] = s
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// BM25 tuning used by MemoryIndex
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchDocument is a block as seen by MemoryIndex. Ancestors lists the ids
+// above the block, nearest parent first; Metas holds its searchable metas.
+type SearchDocument struct {
+	Block     *Block
+	Ancestors []int64
+	Metas     map[string]string
+}
+
+type memoryDoc struct {
+	SearchDocument
+	terms  map[string]int
+	length int
+}
+
+// root returns the top-level block the document belongs to
+func (d *memoryDoc) root() int64 {
+	if len(d.Ancestors) == 0 {
+		return d.Block.ID
+	}
+	return d.Ancestors[len(d.Ancestors)-1]
+}
+
+// MemoryIndex is an in-process inverted index ranked with BM25. It stands in
+// for database full-text search in tests and on databases without it.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[int64]*memoryDoc
+	postings map[string]map[int64]int
+	totalLen int
+}
+
+// NewMemoryIndex returns an empty index
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     map[int64]*memoryDoc{},
+		postings: map[string]map[int64]int{},
+	}
+}
+
+// Add indexes a document, replacing any earlier version of the same block
+func (idx *MemoryIndex) Add(doc SearchDocument) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.add(doc)
+}
+
+func (idx *MemoryIndex) add(doc SearchDocument) {
+	idx.remove(doc.Block.ID)
+
+	text := []string{doc.Block.Title, doc.Block.Content}
+	for _, value := range doc.Metas {
+		text = append(text, value)
+	}
+	d := &memoryDoc{SearchDocument: doc, terms: map[string]int{}}
+	for _, term := range searchTerms(strings.Join(text, " ")) {
+		d.terms[term]++
+		d.length++
+	}
+	for term, tf := range d.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int64]int{}
+		}
+		idx.postings[term][doc.Block.ID] = tf
+	}
+	idx.docs[doc.Block.ID] = d
+	idx.totalLen += d.length
+}
+
+// Remove drops a block from the index
+func (idx *MemoryIndex) Remove(id int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// removeSubtree drops a block and every block indexed below it
+func (idx *MemoryIndex) removeSubtree(id int64) {
+	for docID, d := range idx.docs {
+		if containsID(d.Ancestors, id) {
+			idx.remove(docID)
+		}
+	}
+	idx.remove(id)
+}
+
+func (idx *MemoryIndex) remove(id int64) {
+	d, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range d.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= d.length
+	delete(idx.docs, id)
+}
+
+// Search ranks the documents matching any query term with BM25
+func (idx *MemoryIndex) Search(ctx context.Context, q SearchQuery) (*SearchResults, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := searchTerms(q.Text)
+	ids, scores := idx.rank(terms, q)
+
+	results := &SearchResults{Hits: []SearchHit{}, Total: len(ids)}
+	start := (q.Page - 1) * q.PerPage
+	if start < 0 || q.PerPage < 1 {
+		start = 0
+	}
+	for i := start; i < len(ids) && (q.PerPage < 1 || i < start+q.PerPage); i++ {
+		d := idx.docs[ids[i]]
+		results.Hits = append(results.Hits, SearchHit{
+			Block:      BlockToMap(d.Block),
+			Score:      scores[ids[i]],
+			Highlights: searchHighlights(d.Block, d.Metas, terms),
+		})
+	}
+	return results, nil
+}
+
+// rank scores the documents matching q with BM25 and returns their ids best
+// first. The caller holds the read lock.
+func (idx *MemoryIndex) rank(terms []string, q SearchQuery) ([]int64, map[int64]float64) {
+	scores := map[int64]float64{}
+	if len(idx.docs) > 0 {
+		n := float64(len(idx.docs))
+		avgLen := float64(idx.totalLen) / n
+		for _, term := range uniqueStrings(terms) {
+			posting := idx.postings[term]
+			if len(posting) == 0 {
+				continue
+			}
+			df := float64(len(posting))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, tf := range posting {
+				d := idx.docs[id]
+				if !idx.matches(d, q) {
+					continue
+				}
+				f := float64(tf)
+				scores[id] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(d.length)/avgLen))
+			}
+		}
+	}
+
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+	return ids, scores
+}
+
+// matches applies the scope, parent and type filters of a query
+func (idx *MemoryIndex) matches(d *memoryDoc, q SearchQuery) bool {
+	if !containsID(q.RootIDs, d.root()) {
+		return false
+	}
+	if q.Parent > 0 && !containsID(d.Ancestors, q.Parent) {
+		return false
+	}
+	if len(q.Types) > 0 {
+		for _, t := range q.Types {
+			if t == d.Block.Type {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// LoadMemoryIndex builds an index of every active block whose ancestors are
+// all active, with its searchable metas
+func LoadMemoryIndex(ctx context.Context, db *database.DB) (*MemoryIndex, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+blockColumns+" FROM blocks WHERE status = 1")
+	if err != nil {
+		return nil, err
+	}
+	blocks := map[int64]*Block{}
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blocks[b.ID] = b
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	metas, err := loadSearchableMetas(ctx, db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := NewMemoryIndex()
+	for id, b := range blocks {
+		var ancestors []int64
+		active := true
+		for parent := b.Parent; parent != nil && *parent > 0 && len(ancestors) < maxBlockDepth; {
+			p, ok := blocks[*parent]
+			if !ok {
+				active = false
+				break
+			}
+			ancestors = append(ancestors, *parent)
+			parent = p.Parent
+		}
+		if active {
+			idx.Add(SearchDocument{Block: b, Ancestors: ancestors, Metas: metas[id]})
+		}
+	}
+	return idx, nil
+}
+
+// Subscribe keeps the index in step with the changes published on bus,
+// reading changed blocks back from db. A block that moved, left or came back
+// from the trash is re-indexed with its subtree, so descendants get their new
+// ancestors or leave the index with it.
+func (idx *MemoryIndex) Subscribe(bus *EventBus, db *database.DB) {
+	onBlock := func(ctx context.Context, e Event) {
+		if e.Block == nil {
+			return
+		}
+		if err := idx.reindex(ctx, db, e.Block.ID, idx.moved(e)); err != nil {
+			log.Printf("search index: block %d: %v", e.Block.ID, err)
+		}
+	}
+	bus.Subscribe(EventBlockCreated, onBlock)
+	bus.Subscribe(EventBlockUpdated, onBlock)
+	bus.Subscribe(EventBlockDeleted, onBlock)
+	bus.Subscribe(EventMetaChanged, func(ctx context.Context, e Event) {
+		if !isSearchableMeta(e.Parent, e.Key) {
+			return
+		}
+		if err := idx.reindex(ctx, db, e.ParentID, false); err != nil {
+			log.Printf("search index: block %d: %v", e.ParentID, err)
+		}
+	})
+}
+
+// moved reports whether a block event can change the ancestors or status of
+// the blocks below it
+func (idx *MemoryIndex) moved(e Event) bool {
+	if e.Type != EventBlockUpdated {
+		return e.Type == EventBlockDeleted
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	d, ok := idx.docs[e.Block.ID]
+	if !ok {
+		return true
+	}
+	var indexed, parent int64
+	if len(d.Ancestors) > 0 {
+		indexed = d.Ancestors[0]
+	}
+	if e.Block.Parent != nil {
+		parent = *e.Block.Parent
+	}
+	return parent != indexed
+}
+
+// reindex replaces the indexed copy of a block, and with subtree those of its
+// descendants, with what db holds now. Blocks that are not active, or sit
+// below one that is not, are dropped.
+func (idx *MemoryIndex) reindex(ctx context.Context, db *database.DB, id int64, subtree bool) error {
+	refs, err := ancestorRefs(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	ancestors := map[int64][]int64{id: {}}
+	active := true
+	for _, ref := range refs {
+		active = active && ref.Status == StatusActive
+		ancestors[id] = append(ancestors[id], ref.ID)
+	}
+
+	var blocks []*Block
+	if active {
+		b, err := scanBlock(db.QueryRowContext(ctx, "SELECT "+blockColumns+" FROM blocks WHERE id = ? AND status = 1", id))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+	}
+	if subtree && len(blocks) > 0 {
+		cte, args := descendantsCTE(id, maxBlockDepth, []int{StatusActive}, nil)
+		rows, err := db.QueryContext(ctx, cte+"SELECT "+qualifiedBlockColumns+" FROM tree t INNER JOIN blocks b ON b.id = t.id ORDER BY t.depth, b.id", args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			b, err := scanBlock(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			blocks = append(blocks, b)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	metas := map[int64]map[string]string{}
+	if len(blocks) > 0 {
+		ids := make([]int64, len(blocks))
+		for i, b := range blocks {
+			ids[i] = b.ID
+		}
+		if metas, err = loadSearchableMetas(ctx, db, ids); err != nil {
+			return err
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if subtree {
+		idx.removeSubtree(id)
+	} else {
+		idx.remove(id)
+	}
+	// Blocks arrive parents first
+	for _, b := range blocks {
+		if b.ID != id {
+			ancestors[b.ID] = append([]int64{*b.Parent}, ancestors[*b.Parent]...)
+		}
+		idx.add(SearchDocument{Block: b, Ancestors: ancestors[b.ID], Metas: metas[b.ID]})
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+// hitIDs returns the block ids of search hits in rank order
+func hitIDs(results *SearchResults) []int64 {
+	ids := make([]int64, len(results.Hits))
+	for i, hit := range results.Hits {
+		ids[i] = hit.Block["id"].(int64)
+	}
+	return ids
+}
+
+func sameIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryIndexRanksAndScopes(t *testing.T) {
+	ctx := context.Background()
+	idx := NewMemoryIndex()
+	idx.Add(SearchDocument{Block: &Block{ID: 1, Type: "workspace", Title: "Billing"}})
+	idx.Add(SearchDocument{Block: &Block{ID: 2, Type: "thread", Title: "Refund", Content: "refund refund please"}, Ancestors: []int64{1}})
+	idx.Add(SearchDocument{Block: &Block{ID: 3, Type: "thread", Title: "Invoice", Content: "a refund for the invoice"}, Ancestors: []int64{1}})
+	idx.Add(SearchDocument{Block: &Block{ID: 4, Type: "workspace", Title: "Refund policy"}})
+
+	results, err := idx.Search(ctx, SearchQuery{Text: "refund", RootIDs: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := hitIDs(results); !sameIDs(ids, []int64{2, 3}) || results.Total != 2 {
+		t.Errorf("got %v of %d, want the threads of workspace 1, most mentions first", ids, results.Total)
+	}
+	if results.Hits[0].Highlights["title"] != "<mark>Refund</mark>" {
+		t.Errorf("highlights = %v", results.Hits[0].Highlights)
+	}
+
+	results, _ = idx.Search(ctx, SearchQuery{Text: "refund", RootIDs: []int64{1, 4}, Types: []string{"workspace"}})
+	if ids := hitIDs(results); !sameIDs(ids, []int64{4}) {
+		t.Errorf("workspaces only: got %v, want [4]", ids)
+	}
+
+	results, _ = idx.Search(ctx, SearchQuery{Text: "refund", RootIDs: []int64{1, 4}, Page: 2, PerPage: 2})
+	if ids := hitIDs(results); len(ids) != 1 || results.Total != 3 {
+		t.Errorf("second page: got %v of %d, want 1 of 3", ids, results.Total)
+	}
+
+	// Adding a block again replaces it
+	idx.Add(SearchDocument{Block: &Block{ID: 2, Type: "thread", Title: "Shipping"}, Ancestors: []int64{1}})
+	idx.Remove(3)
+	results, _ = idx.Search(ctx, SearchQuery{Text: "refund", RootIDs: []int64{1}})
+	if results.Total != 0 {
+		t.Errorf("after replacing and removing: got %v, want nothing", hitIDs(results))
+	}
+}
+
+func TestLoadMemoryIndexSkipsTrashedBlocks(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := addWorkspace(t, dm, "Kept")
+	trashed := addWorkspace(t, dm, "Trashed")
+	if _, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "thread", "title": "Orphan", "content": "", "parent": trashed}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.AddMeta(ctx, "workspace", kept, "description", "walrus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.DeleteBlock(ctx, trashed); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := LoadMemoryIndex(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []int64{kept, trashed}
+
+	results, _ := idx.Search(ctx, SearchQuery{Text: "walrus", RootIDs: roots})
+	if ids := hitIDs(results); !sameIDs(ids, []int64{kept}) {
+		t.Errorf("searchable meta: got %v, want [%d]", ids, kept)
+	}
+	for _, text := range []string{"trashed", "orphan"} {
+		results, _ = idx.Search(ctx, SearchQuery{Text: text, RootIDs: roots})
+		if results.Total != 0 {
+			t.Errorf("%q found %v, want trashed blocks and their children left out", text, hitIDs(results))
+		}
+	}
+}
+
+func TestMemoryIndexFollowsChanges(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadMemoryIndex(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Subscribe(useEventBus(t), db)
+
+	alpha := addWorkspace(t, dm, "Alpha")
+	beta := addWorkspace(t, dm, "Beta")
+	thread, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "thread", "title": "Walrus", "content": "", "parent": alpha}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threadID := thread["id"].(int64)
+
+	search := func(text string, root int64) []int64 {
+		t.Helper()
+		results, err := idx.Search(ctx, SearchQuery{Text: text, RootIDs: []int64{root}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hitIDs(results)
+	}
+
+	if ids := search("walrus", alpha); !sameIDs(ids, []int64{threadID}) {
+		t.Errorf("new thread: got %v", ids)
+	}
+
+	if _, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "thread", "title": "Narwhal", "content": ""}, thread["slug"].(string)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("walrus", alpha); len(ids) != 0 {
+		t.Errorf("old title still found: %v", ids)
+	}
+	if ids := search("narwhal", alpha); !sameIDs(ids, []int64{threadID}) {
+		t.Errorf("new title: got %v", ids)
+	}
+
+	if err := dm.AddMeta(ctx, "workspace", alpha, "description", "seals"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("seals", alpha); !sameIDs(ids, []int64{alpha}) {
+		t.Errorf("searchable meta: got %v", ids)
+	}
+
+	if err := dm.MoveBlock(ctx, threadID, beta); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("narwhal", alpha); len(ids) != 0 {
+		t.Errorf("moved thread still found under its old workspace: %v", ids)
+	}
+	if ids := search("narwhal", beta); !sameIDs(ids, []int64{threadID}) {
+		t.Errorf("moved thread under its new workspace: got %v", ids)
+	}
+
+	if err := dm.DeleteBlock(ctx, beta); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("narwhal", beta); len(ids) != 0 {
+		t.Errorf("thread of a trashed workspace still found: %v", ids)
+	}
+	if err := dm.RestoreBlock(ctx, beta); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("narwhal", beta); !sameIDs(ids, []int64{threadID}) {
+		t.Errorf("thread of a restored workspace: got %v", ids)
+	}
+}
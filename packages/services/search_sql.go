@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// SQLSearch searches with the database's own full-text support: MySQL FULLTEXT
+// indexes or PostgreSQL tsvector matching (see packages/migrations). On SQLite,
+// which is meant for development and tests, blocks score one point per query
+// term they contain.
+type SQLSearch struct {
+	db *database.DB
+}
+
+// NewSQLSearch returns a search backend using the full-text support of db
+func NewSQLSearch(db *database.DB) *SQLSearch {
+	return &SQLSearch{db: db}
+}
+
+// readableCTE builds a recursive CTE named readable (id, depth) holding the
+// active blocks below q.Parent, or the active roots in q.RootIDs and their
+// descendants
+func readableCTE(q SearchQuery) (string, []interface{}) {
+	base := "SELECT b.id, 0 FROM blocks b WHERE b.id IN (" + placeholders(len(q.RootIDs)) + ") AND b.status = 1"
+	args := int64Args(q.RootIDs)
+	if q.Parent > 0 {
+		base = "SELECT b.id, 1 FROM blocks b WHERE b.parent = ? AND b.status = 1"
+		args = []interface{}{q.Parent}
+	}
+
+	query := `WITH RECURSIVE readable (id, depth) AS (
+    ` + base + `
+    UNION ALL
+    SELECT b.id, r.depth + 1 FROM blocks b INNER JOIN readable r ON b.parent = r.id
+    WHERE r.depth < ? AND b.status = 1
+)
+`
+	return query, append(args, maxBlockDepth)
+}
+
+// matchExprs returns the SQL scoring a block's title and content and the
+// SQL scoring one meta m against the query text, with the arguments of either
+func (s *SQLSearch) matchExprs(text string) (string, string, []interface{}) {
+	switch s.db.Dialect {
+	case database.Postgres:
+		return "ts_rank(to_tsvector('simple', b.title || ' ' || b.content), plainto_tsquery('simple', ?))",
+			"ts_rank(to_tsvector('simple', m.meta_value), plainto_tsquery('simple', ?))",
+			[]interface{}{text}
+	case database.SQLite:
+		terms := searchTerms(text)
+		if len(terms) == 0 {
+			return "0", "0", nil
+		}
+		blockMatch := make([]string, len(terms))
+		metaMatch := make([]string, len(terms))
+		args := make([]interface{}, len(terms))
+		for i, term := range terms {
+			blockMatch[i] = "(instr(LOWER(b.title || ' ' || b.content), ?) > 0)"
+			metaMatch[i] = "(instr(LOWER(m.meta_value), ?) > 0)"
+			args[i] = term
+		}
+		return "(" + strings.Join(blockMatch, " + ") + ")", "(" + strings.Join(metaMatch, " + ") + ")", args
+	}
+	return "MATCH (b.title, b.content) AGAINST (? IN NATURAL LANGUAGE MODE)",
+		"MATCH (m.meta_value) AGAINST (? IN NATURAL LANGUAGE MODE)",
+		[]interface{}{text}
+}
+
+// Search ranks matching blocks by title and content relevance plus the best
+// matching searchable meta
+func (s *SQLSearch) Search(ctx context.Context, q SearchQuery) (*SearchResults, error) {
+	cte, cteArgs := readableCTE(q)
+	blockMatch, metaMatch, matchArgs := s.matchExprs(q.Text)
+	searchable := searchableMetas()
+
+	score := blockMatch
+	scoreArgs := append([]interface{}{}, matchArgs...)
+	where := blockMatch + " > 0"
+	whereArgs := append([]interface{}{}, matchArgs...)
+	if len(searchable) > 0 {
+		condition, keyArgs := searchableMetaCondition(searchable)
+		metaScope := "FROM metas m WHERE m.parent = b.type AND m.parent_id = b.id AND m.status = 1 AND " + condition
+
+		score = "(" + score + " + COALESCE((SELECT MAX(" + metaMatch + ") " + metaScope + "), 0))"
+		scoreArgs = append(append(scoreArgs, matchArgs...), keyArgs...)
+		where = "(" + where + " OR EXISTS (SELECT 1 " + metaScope + " AND " + metaMatch + " > 0))"
+		whereArgs = append(append(whereArgs, keyArgs...), matchArgs...)
+	}
+	if len(q.Types) > 0 {
+		where += " AND b.type IN (" + placeholders(len(q.Types)) + ")"
+		for _, t := range q.Types {
+			whereArgs = append(whereArgs, t)
+		}
+	}
+	from := " FROM readable r INNER JOIN blocks b ON b.id = r.id WHERE " + where
+
+	var total int
+	countArgs := append(append([]interface{}{}, cteArgs...), whereArgs...)
+	if err := s.db.QueryRowContext(ctx, cte+"SELECT COUNT(*)"+from, countArgs...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	args := append(append([]interface{}{}, cteArgs...), scoreArgs...)
+	args = append(args, whereArgs...)
+	args = append(args, q.PerPage, (q.Page-1)*q.PerPage)
+	rows, err := s.db.QueryContext(ctx,
+		cte+fmt.Sprintf("SELECT %s, %s AS score%s ORDER BY score DESC, b.id DESC LIMIT ? OFFSET ?", qualifiedBlockColumns, score, from),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []*Block
+	var scores []float64
+	for rows.Next() {
+		var b Block
+		var score float64
+		if err := rows.Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt, &b.Position, &b.Version, &score); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &b)
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(blocks))
+	for i, b := range blocks {
+		ids[i] = b.ID
+	}
+	metas := map[int64]map[string]string{}
+	if len(ids) > 0 {
+		metas, err = loadSearchableMetas(ctx, s.db, ids)
+	}
+	if err != nil {
+		return nil, err
+	}
+	terms := searchTerms(q.Text)
+	hits := make([]SearchHit, 0, len(blocks))
+	for i, b := range blocks {
+		hits = append(hits, SearchHit{
+			Block:      BlockToMap(b),
+			Score:      scores[i],
+			Highlights: searchHighlights(b, metas[b.ID], terms),
+		})
+	}
+	return &SearchResults{Hits: hits, Total: total}, nil
+}
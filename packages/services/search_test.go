@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSecretMetasAreNotSearchedUnderAnotherTypesKey(t *testing.T) {
+	ctx := context.Background()
+	registerTestType(t, BlockTypeDefinition{
+		Name:  "vault",
+		Metas: []MetaField{{Name: "description", Type: MetaString, Secret: true}},
+	})
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := addWorkspace(t, dm, "Shop")
+	if err := dm.AddMeta(ctx, "workspace", workspace, "description", "walrus"); err != nil {
+		t.Fatal(err)
+	}
+	vault, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "vault", "title": "Keys", "content": ""}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultID := vault["id"].(int64)
+	// A value stored before the meta was declared secret is not sealed
+	if err := dm.stores.Metas.SetMeta(ctx, "vault", vaultID, "description", "walrus password"); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadMemoryIndex(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, backend := range map[string]SearchBackend{"sql": NewSQLSearch(db), "memory": index} {
+		results, err := backend.Search(ctx, SearchQuery{Text: "walrus", RootIDs: []int64{workspace, vaultID}, Page: 1, PerPage: 20})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if ids := hitIDs(results); !sameIDs(ids, []int64{workspace}) {
+			t.Errorf("%s: got %v, want only the workspace %d", name, ids, workspace)
+		}
+	}
+}
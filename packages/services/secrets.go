@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// Sealed secrets are stored as
+//
+//	secret:v1:<key id>:<hint>:<wrapped data key>:<ciphertext>
+//
+// Each value is encrypted with its own random data key, and the data key is
+// encrypted with the master key named by key id. Rotating the master key only
+// re-wraps the data keys. The ciphertext is bound to the parent, parent id and
+// key of its meta.
+const secretPrefix = "secret:v1:"
+
+// secretMask is shown instead of secret values by read APIs
+const secretMask = "••••"
+
+// ErrSecretsNotConfigured is returned when secrets are written or rotated
+// without any master keys loaded
+var ErrSecretsNotConfigured = errors.New("secret keys are not configured (set SECRET_KEYS)")
+
+var (
+	secretKeysMu    sync.RWMutex
+	secretKeys      = map[string][]byte{}
+	activeSecretKey string
+)
+
+// LoadSecretKeys configures the master keys used for secret metas. spec is a
+// comma separated list of id:base64key pairs holding 32 byte AES keys, and
+// active names the key new secrets are sealed with. When active is empty the
+// first key in spec is used.
+func LoadSecretKeys(spec, active string) error {
+	keys := map[string][]byte{}
+	first := ""
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || strings.ContainsAny(id, ": ") {
+			return fmt.Errorf("invalid secret key entry %q, expected id:base64key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("secret key %s is not valid base64: %v", id, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("secret key %s must be 32 bytes, got %d", id, len(key))
+		}
+		keys[id] = key
+		if first == "" {
+			first = id
+		}
+	}
+
+	if active == "" {
+		active = first
+	}
+	if active != "" {
+		if _, ok := keys[active]; !ok {
+			return fmt.Errorf("active secret key %s is not in SECRET_KEYS", active)
+		}
+	}
+
+	secretKeysMu.Lock()
+	defer secretKeysMu.Unlock()
+	secretKeys = keys
+	activeSecretKey = active
+	return nil
+}
+
+// SecretsConfigured reports whether master keys are loaded. Without them
+// secret metas cannot be written.
+func SecretsConfigured() bool {
+	secretKeysMu.RLock()
+	defer secretKeysMu.RUnlock()
+	return activeSecretKey != ""
+}
+
+// isSecretMeta reports whether a block type declares the meta as secret
+func isSecretMeta(parent, key string) bool {
+	def, ok := GetBlockTypeDefinition(parent)
+	if !ok {
+		return false
+	}
+	field, ok := def.GetMetaField(key)
+	return ok && field.Secret
+}
+
+// isSecretMetaKey reports whether any block type declares a meta with this key
+// as secret. List options are parsed before the block type is known, so they
+// refuse such keys on every type.
+func isSecretMetaKey(key string) bool {
+	for _, def := range BlockTypeDefinitions() {
+		if field, ok := def.GetMetaField(key); ok && field.Secret {
+			return true
+		}
+	}
+	return false
+}
+
+func isSealedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+func gcmSeal(key, plaintext, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func gcmOpen(key, sealed, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+// secretHint keeps the last four characters of long secrets so users can tell
+// them apart
+func secretHint(plaintext string) string {
+	if utf8.RuneCountInString(plaintext) < 12 {
+		return ""
+	}
+	runes := []rune(plaintext)
+	return string(runes[len(runes)-4:])
+}
+
+// secretAAD binds a sealed value to the meta it was written for, so it cannot
+// be copied into another parent's meta
+func secretAAD(parent string, parentID int64, key string) []byte {
+	return []byte(fmt.Sprintf("%s/%d/%s", parent, parentID, key))
+}
+
+// sealSecret encrypts a meta value under the active master key
+func sealSecret(parent string, parentID int64, key, plaintext string) (string, error) {
+	secretKeysMu.RLock()
+	kid, master := activeSecretKey, secretKeys[activeSecretKey]
+	secretKeysMu.RUnlock()
+	if kid == "" {
+		return "", ErrSecretsNotConfigured
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := gcmSeal(dataKey, []byte(plaintext), secretAAD(parent, parentID, key))
+	if err != nil {
+		return "", err
+	}
+	return wrapSecret(kid, master, secretHint(plaintext), dataKey, ciphertext)
+}
+
+func wrapSecret(kid string, master []byte, hint string, dataKey, ciphertext []byte) (string, error) {
+	wrapped, err := gcmSeal(master, dataKey, []byte(kid))
+	if err != nil {
+		return "", err
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	return secretPrefix + strings.Join([]string{kid, encode([]byte(hint)), encode(wrapped), encode(ciphertext)}, ":"), nil
+}
+
+type sealedSecret struct {
+	kid        string
+	hint       string
+	wrapped    []byte
+	ciphertext []byte
+}
+
+func parseSealedSecret(value string) (*sealedSecret, error) {
+	if !isSealedSecret(value) {
+		return nil, errors.New("malformed sealed secret")
+	}
+	parts := strings.Split(value[len(secretPrefix):], ":")
+	if len(parts) != 4 {
+		return nil, errors.New("malformed sealed secret")
+	}
+
+	decoded := make([][]byte, 3)
+	for i, part := range parts[1:] {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, errors.New("malformed sealed secret")
+		}
+		decoded[i] = b
+	}
+	return &sealedSecret{kid: parts[0], hint: string(decoded[0]), wrapped: decoded[1], ciphertext: decoded[2]}, nil
+}
+
+// dataKey unwraps the data key of a sealed secret
+func (s *sealedSecret) dataKey() ([]byte, error) {
+	secretKeysMu.RLock()
+	master, ok := secretKeys[s.kid]
+	secretKeysMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("secret key %s is not configured", s.kid)
+	}
+	return gcmOpen(master, s.wrapped, []byte(s.kid))
+}
+
+// openSecret decrypts a stored meta value. Values written before the meta was
+// declared secret, or before keys were configured, are returned as they are.
+func openSecret(parent string, parentID int64, key, stored string) (string, error) {
+	if !isSealedSecret(stored) {
+		return stored, nil
+	}
+	sealed, err := parseSealedSecret(stored)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := sealed.dataKey()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcmOpen(dataKey, sealed.ciphertext, secretAAD(parent, parentID, key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %v", key, err)
+	}
+	return string(plaintext), nil
+}
+
+// readSecret decrypts a secret meta for server-side use, and returns an empty
+// string when the meta is not set. It is unexported so that handlers, which
+// only see masked values, have no way to return a secret.
+func (dm *DatabaseManager) readSecret(ctx context.Context, parent string, parentID int64, key string) (string, error) {
+	if !isSecretMeta(parent, key) {
+		return "", fmt.Errorf("%s of %s is not a secret meta", key, parent)
+	}
+	stored, ok, err := dm.stores.Metas.GetMeta(ctx, parent, parentID, key)
+	if err != nil || !ok {
+		return "", err
+	}
+	return openSecret(parent, parentID, key, stored)
+}
+
+// maskSecret renders a stored secret as a masked hint
+func maskSecret(stored string) string {
+	if stored == "" {
+		return ""
+	}
+	hint := ""
+	if sealed, err := parseSealedSecret(stored); err == nil {
+		hint = sealed.hint
+	} else if !isSealedSecret(stored) {
+		hint = secretHint(stored)
+	}
+	return secretMask + hint
+}
+
+// maskSecretMetas replaces secret values in a meta map with masked hints
+func maskSecretMetas(parent string, metas map[string]string) {
+	for key, value := range metas {
+		if isSecretMeta(parent, key) {
+			metas[key] = maskSecret(value)
+		}
+	}
+}
+
+// RotateSecrets re-encrypts every secret meta that is not sealed with the
+// active master key, including plain values written before a meta was declared
+// secret, and returns how many were rewritten. Old keys must still be loaded
+// so existing secrets can be unwrapped.
+func RotateSecrets(ctx context.Context, db *database.DB) (int, error) {
+	secretKeysMu.RLock()
+	kid, master := activeSecretKey, secretKeys[activeSecretKey]
+	secretKeysMu.RUnlock()
+	if kid == "" {
+		return 0, ErrSecretsNotConfigured
+	}
+
+	rotated := 0
+	for _, def := range BlockTypeDefinitions() {
+		for _, field := range def.Metas {
+			if !field.Secret {
+				continue
+			}
+			n, err := rotateSecretMeta(ctx, db, def.Name, field.Name, kid, master)
+			rotated += n
+			if err != nil {
+				return rotated, err
+			}
+		}
+	}
+	return rotated, nil
+}
+
+func rotateSecretMeta(ctx context.Context, db *database.DB, parent, key, kid string, master []byte) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, parent_id, meta_value FROM metas WHERE parent = ? AND meta_key = ? AND meta_value <> ''", parent, key)
+	if err != nil {
+		return 0, err
+	}
+	type storedMeta struct {
+		id       int64
+		parentID int64
+		value    string
+	}
+	var metas []storedMeta
+	for rows.Next() {
+		var m storedMeta
+		if err := rows.Scan(&m.id, &m.parentID, &m.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		metas = append(metas, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, m := range metas {
+		var value string
+		if !isSealedSecret(m.value) {
+			var err error
+			if value, err = sealSecret(parent, m.parentID, key, m.value); err != nil {
+				return rotated, err
+			}
+		} else {
+			sealed, err := parseSealedSecret(m.value)
+			if err != nil {
+				return rotated, fmt.Errorf("meta %d: %v", m.id, err)
+			}
+			if sealed.kid == kid {
+				continue
+			}
+			dataKey, err := sealed.dataKey()
+			if err != nil {
+				return rotated, fmt.Errorf("meta %d: %v", m.id, err)
+			}
+			if value, err = wrapSecret(kid, master, sealed.hint, dataKey, sealed.ciphertext); err != nil {
+				return rotated, err
+			}
+		}
+
+		// Only replace the value we read so a concurrent write is not lost
+		res, err := db.ExecContext(ctx, "UPDATE metas SET meta_value = ? WHERE id = ? AND meta_value = ?", value, m.id, m.value)
+		if err != nil {
+			return rotated, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
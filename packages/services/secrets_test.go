@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// useSecretKeys loads one master key for the rest of the test
+func useSecretKeys(t *testing.T) {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	if err := LoadSecretKeys("k1:"+key, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadSecretKeys("", "") })
+}
+
+func addWorkspace(t *testing.T, dm *DatabaseManager, title string) int64 {
+	t.Helper()
+	block, err := dm.AddBlock(context.Background(), 1, map[string]interface{}{"type": "workspace", "title": title, "content": ""}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block["id"].(int64)
+}
+
+func TestSecretWritesAreRefusedWithoutKeys(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Shop")
+
+	if err := dm.AddMeta(ctx, "workspace", id, "stripe_secret_key", "sk_test_1234567890"); !errors.Is(err, ErrSecretsNotConfigured) {
+		t.Fatalf("saving a secret without keys: %v, want ErrSecretsNotConfigured", err)
+	}
+	if _, ok, _ := dm.stores.Metas.GetMeta(ctx, "workspace", id, "stripe_secret_key"); ok {
+		t.Error("the secret was stored")
+	}
+}
+
+func TestRotateSecretsSealsPlainValues(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Shop")
+
+	// A value stored before the meta was declared secret
+	if err := dm.stores.Metas.SetMeta(ctx, "workspace", id, "stripe_secret_key", "sk_test_1234567890"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := dm.GetMeta(ctx, "workspace", id, "stripe_secret_key"); value != secretMask+"7890" {
+		t.Errorf("read back %q, want the masked hint", value)
+	}
+
+	useSecretKeys(t)
+	if n, err := RotateSecrets(ctx, db); err != nil || n != 1 {
+		t.Fatalf("RotateSecrets = %d, %v, want 1 sealed", n, err)
+	}
+	stored, _, _ := dm.stores.Metas.GetMeta(ctx, "workspace", id, "stripe_secret_key")
+	if !strings.HasPrefix(stored, secretPrefix) {
+		t.Fatalf("stored value %q is not sealed", stored)
+	}
+	if plaintext, err := openSecret("workspace", id, "stripe_secret_key", stored); err != nil || plaintext != "sk_test_1234567890" {
+		t.Errorf("openSecret = %q, %v", plaintext, err)
+	}
+}
+
+func TestSealedSecretIsBoundToItsParent(t *testing.T) {
+	useSecretKeys(t)
+	sealed, err := sealSecret("workspace", 1, "stripe_secret_key", "sk_test_1234567890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSecret("workspace", 2, "stripe_secret_key", sealed); err == nil {
+		t.Error("a secret copied to another workspace was decrypted")
+	}
+	if plaintext, err := openSecret("workspace", 1, "stripe_secret_key", sealed); err != nil || plaintext != "sk_test_1234567890" {
+		t.Errorf("openSecret = %q, %v", plaintext, err)
+	}
+}
+
+func TestReadSecretRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	useSecretKeys(t)
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Shop")
+
+	if value, err := dm.readSecret(ctx, "workspace", id, "stripe_secret_key"); err != nil || value != "" {
+		t.Errorf("unset secret = %q, %v, want empty", value, err)
+	}
+	if err := dm.AddMeta(ctx, "workspace", id, "stripe_secret_key", "sk_live_1234567890"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := dm.GetMeta(ctx, "workspace", id, "stripe_secret_key"); value != secretMask+"7890" {
+		t.Errorf("GetMeta = %q, want the masked hint", value)
+	}
+	if value, err := dm.readSecret(ctx, "workspace", id, "stripe_secret_key"); err != nil || value != "sk_live_1234567890" {
+		t.Errorf("readSecret = %q, %v", value, err)
+	}
+	if _, err := dm.readSecret(ctx, "workspace", id, "description"); err == nil {
+		t.Error("readSecret read a meta that is not secret")
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Setting value types understood by the settings schema
+const (
+	SettingString = "string"
+	SettingText   = "text"
+	SettingColor  = "color"
+	SettingURL    = "url"
+	SettingEnum   = "enum"
+)
+
+// SettingDefinition describes one configurable setting. Settings are stored as
+// metas on the "system" and "workspace" parents under their Key.
+type SettingDefinition struct {
+	Key       string   `json:"key"`
+	Type      string   `json:"type"`
+	Default   string   `json:"default"`
+	Options   []string `json:"options,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+}
+
+var settingDefinitions = []SettingDefinition{
+	{Key: "brand_name", Type: SettingString, Default: "Typewriting", MaxLength: 120},
+	{Key: "logo", Type: SettingURL, Default: "", MaxLength: 500},
+	{Key: "primary_color", Type: SettingColor, Default: "#007bff"},
+	{Key: "secondary_color", Type: SettingColor, Default: "#6c757d"},
+	{Key: "sender_name", Type: SettingString, Default: "The Typewriting Team", MaxLength: 120},
+	{Key: "default_language", Type: SettingEnum, Default: "en", Options: []string{"en", "es", "fr", "de", "pt", "it", "nl", "bn"}},
+	{Key: "llm_model", Type: SettingEnum, Default: "gpt-4o-mini", Options: []string{"gpt-4o-mini", "gpt-4o", "gpt-4.1", "gpt-4.1-mini"}},
+	{Key: "greeting_message", Type: SettingText, Default: "Hi there! How can I help you today?", MaxLength: 2000},
+}
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// SettingDefinitions returns the schema of all known settings
+func SettingDefinitions() []SettingDefinition {
+	defs := make([]SettingDefinition, len(settingDefinitions))
+	copy(defs, settingDefinitions)
+	return defs
+}
+
+// GetSettingDefinition looks up a setting by key
+func GetSettingDefinition(key string) (SettingDefinition, bool) {
+	for _, def := range settingDefinitions {
+		if def.Key == key {
+			return def, true
+		}
+	}
+	return SettingDefinition{}, false
+}
+
+// Validate checks a value against the definition. An empty value is always
+// accepted and means "inherit from the level above".
+func (d SettingDefinition) Validate(value string) error {
+	if value == "" {
+		return nil
+	}
+	if d.MaxLength > 0 && len(value) > d.MaxLength {
+		return fmt.Errorf("must be at most %d characters", d.MaxLength)
+	}
+
+	switch d.Type {
+	case SettingColor:
+		if !colorPattern.MatchString(value) {
+			return fmt.Errorf("must be a hex color like #1a2b3c")
+		}
+	case SettingURL:
+		if strings.HasPrefix(value, "/") {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("must be an http(s) URL or an absolute path")
+		}
+	case SettingEnum:
+		for _, option := range d.Options {
+			if option == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(d.Options, ", "))
+	}
+	return nil
+}
+
+// GetSettings resolves the effective settings for the current system and, when
+// workspaceID is non-zero, the given workspace. Values are inherited
+// defaults -> system -> workspace, with empty values falling through.
+func (dm *DatabaseManager) GetSettings(ctx context.Context, workspaceID int64) (map[string]string, error) {
+	settings := make(map[string]string, len(settingDefinitions))
+	for _, def := range settingDefinitions {
+		settings[def.Key] = def.Default
+	}
+
+	levels := []struct {
+		parent string
+		id     int64
+	}{
+		{"system", dm.systemID},
+		{"workspace", workspaceID},
+	}
+
+	for _, level := range levels {
+		if level.id <= 0 {
+			continue
+		}
+		metas, err := dm.GetMetas(ctx, level.id, level.parent, []string{})
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range settingDefinitions {
+			if value := metas[def.Key]; value != "" {
+				settings[def.Key] = value
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// UpdateSettings validates and stores settings on a "system" or "workspace"
+// parent. Nothing is written unless every value validates.
+func (dm *DatabaseManager) UpdateSettings(ctx context.Context, parent string, parentID int64, values map[string]interface{}) error {
+	if parent != "system" && parent != "workspace" {
+		return fmt.Errorf("settings are not supported on %q", parent)
+	}
+
+	fieldErrors := &ValidationError{}
+	validated := make(map[string]interface{}, len(values))
+	for key, raw := range values {
+		def, ok := GetSettingDefinition(key)
+		if !ok {
+			fieldErrors.Add(key, "unknown setting")
+			continue
+		}
+
+		var value string
+		switch v := raw.(type) {
+		case nil:
+			value = ""
+		case string:
+			value = strings.TrimSpace(v)
+		default:
+			fieldErrors.Add(key, "must be a string")
+			continue
+		}
+
+		if err := def.Validate(value); err != nil {
+			fieldErrors.Add(key, err.Error())
+			continue
+		}
+		validated[key] = value
+	}
+
+	if err := fieldErrors.OrNil(); err != nil {
+		return err
+	}
+
+	return dm.SetMetas(ctx, parent, parentID, validated)
+}
+
+// GrantSystemAdmin gives the user with email the admin privilege on the system
+// serving domain, which lets them change its settings. The system is created
+// when it does not exist yet.
+func GrantSystemAdmin(ctx context.Context, stores Stores, domain, email string) error {
+	systemID, err := stores.Systems.FindSystem(ctx, domain)
+	if err == nil && systemID == 0 {
+		systemID, err = stores.Systems.AddSystem(ctx, domain)
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := stores.Users.FindUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.SystemID != systemID {
+		return fmt.Errorf("no user %s on %s", email, domain)
+	}
+
+	key := fmt.Sprintf("privilege_%d", user.ID)
+	var privileges []string
+	if value, ok, err := stores.Metas.GetMeta(ctx, "system", systemID, key); err != nil {
+		return err
+	} else if ok {
+		if err := json.Unmarshal([]byte(value), &privileges); err != nil {
+			return fmt.Errorf("privileges of %s: %v", email, err)
+		}
+	}
+	for _, p := range privileges {
+		if p == "admin" {
+			return nil
+		}
+	}
+
+	value, err := json.Marshal(append(privileges, "admin"))
+	if err != nil {
+		return err
+	}
+	return stores.Metas.SetMeta(ctx, "system", systemID, key, string(value))
+}
@@ -0,0 +1,63 @@
+package services
+
+import "context"
+
+// User is an account of a system
+type User struct {
+	ID        int64
+	SystemID  int64
+	Email     string
+	Password  string
+	AccessKey string
+}
+
+// BlockStore reads single blocks. Queries over many blocks (lists, trees,
+// search) run on the SQL database of the DatabaseManager.
+type BlockStore interface {
+	// FindBlock returns an active block by id or slug, or nil when none
+	// matches. An empty blockType matches any type.
+	FindBlock(ctx context.Context, blockType string, id int64, slug string) (*Block, error)
+	// CountChildren counts the active blocks of childType directly under
+	// active blocks of parentType written by author
+	CountChildren(ctx context.Context, parentType, childType string, author int64) (int, error)
+}
+
+// MetaStore reads and writes metas as stored: secrets stay sealed and values
+// are not validated
+type MetaStore interface {
+	// GetMeta returns an active meta; the boolean is false when it is missing
+	GetMeta(ctx context.Context, parent string, parentID int64, key string) (string, bool, error)
+	// GetMetas returns the active metas of a parent, or only those with keys
+	GetMetas(ctx context.Context, parent string, parentID int64, keys []string) (map[string]string, error)
+	// SetMeta creates or replaces a meta and marks it active
+	SetMeta(ctx context.Context, parent string, parentID int64, key, value string) error
+	// FindParentID returns the parent id of the first active meta with key
+	// whose value contains every one of contains, or 0 when none does
+	FindParentID(ctx context.Context, parent, key string, contains ...string) (int64, error)
+}
+
+// UserStore reads and creates users. Lookups return nil when no user matches.
+type UserStore interface {
+	FindUser(ctx context.Context, id int64) (*User, error)
+	FindUserByAccessKey(ctx context.Context, accessKey string) (*User, error)
+	// FindUserByEmail ignores the case of email
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
+	AddUser(ctx context.Context, u User) (int64, error)
+}
+
+// SystemStore resolves the system (tenant) serving a domain
+type SystemStore interface {
+	// FindSystem returns the id of the active system whose subdomain or
+	// domain is domain, ignoring case, or 0 when there is none
+	FindSystem(ctx context.Context, domain string) (int64, error)
+	// AddSystem creates an active system for domain
+	AddSystem(ctx context.Context, domain string) (int64, error)
+}
+
+// Stores groups the storage a DatabaseManager and the controllers depend on
+type Stores struct {
+	Blocks  BlockStore
+	Metas   MetaStore
+	Users   UserStore
+	Systems SystemStore
+}
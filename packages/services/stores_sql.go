@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// NewSQLStores returns stores backed by the blocks, metas, users and systems
+// tables of db
+func NewSQLStores(db *database.DB) Stores {
+	return Stores{
+		Blocks:  sqlBlockStore{sqlStore{db: db}},
+		Metas:   sqlMetaStore{sqlStore{db: db}},
+		Users:   sqlUserStore{sqlStore{db: db}},
+		Systems: sqlSystemStore{sqlStore{db: db}},
+	}
+}
+
+// withTx returns stores whose SQL stores run in tx. Other stores are not
+// transactional and are returned as they are.
+func (s Stores) withTx(tx *database.Tx) Stores {
+	if store, ok := s.Blocks.(sqlBlockStore); ok {
+		store.tx = tx
+		s.Blocks = store
+	}
+	if store, ok := s.Metas.(sqlMetaStore); ok {
+		store.tx = tx
+		s.Metas = store
+	}
+	if store, ok := s.Users.(sqlUserStore); ok {
+		store.tx = tx
+		s.Users = store
+	}
+	if store, ok := s.Systems.(sqlSystemStore); ok {
+		store.tx = tx
+		s.Systems = store
+	}
+	return s
+}
+
+// sqlStore runs queries on db, or in tx when one is set
+type sqlStore struct {
+	db *database.DB
+	tx *database.Tx
+}
+
+func (s sqlStore) q() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+type sqlBlockStore struct {
+	sqlStore
+}
+
+func (s sqlBlockStore) FindBlock(ctx context.Context, blockType string, id int64, slug string) (*Block, error) {
+	if id <= 0 && slug == "" {
+		return nil, nil
+	}
+
+	query := "SELECT " + blockColumns + " FROM blocks WHERE status = 1"
+	args := []interface{}{}
+	if blockType != "" {
+		query += " AND type = ?"
+		args = append(args, blockType)
+	}
+	if id > 0 {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	if slug != "" {
+		query += " AND slug = ?"
+		args = append(args, slug)
+	}
+
+	b, err := scanBlock(s.q().QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s sqlBlockStore) CountChildren(ctx context.Context, parentType, childType string, author int64) (int, error) {
+	var count int
+	err := s.q().QueryRowContext(ctx, `
+		SELECT COUNT(c.id)
+		FROM blocks c
+		INNER JOIN blocks p ON c.parent = p.id
+		WHERE c.type = ? AND c.status = 1
+		AND p.type = ? AND p.status = 1
+		AND p.author = ?
+	`, childType, parentType, author).Scan(&count)
+	return count, err
+}
+
+type sqlMetaStore struct {
+	sqlStore
+}
+
+func (s sqlMetaStore) GetMeta(ctx context.Context, parent string, parentID int64, key string) (string, bool, error) {
+	var value string
+	err := s.q().QueryRowContext(ctx,
+		"SELECT meta_value FROM metas WHERE parent = ? AND parent_id = ? AND meta_key = ? AND status = 1",
+		parent, parentID, key,
+	).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (s sqlMetaStore) GetMetas(ctx context.Context, parent string, parentID int64, keys []string) (map[string]string, error) {
+	query := "SELECT meta_key, meta_value FROM metas WHERE parent = ? AND parent_id = ? AND status = 1"
+	args := []interface{}{parent, parentID}
+	if len(keys) > 0 {
+		query += " AND meta_key IN (" + placeholders(len(keys)) + ")"
+		for _, key := range keys {
+			args = append(args, key)
+		}
+	}
+
+	rows, err := s.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metas := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		metas[key] = value
+	}
+	return metas, rows.Err()
+}
+
+func (s sqlMetaStore) SetMeta(ctx context.Context, parent string, parentID int64, key, value string) error {
+	_, err := s.q().ExecContext(ctx,
+		s.db.Dialect.Upsert("metas",
+			[]string{"parent", "parent_id", "meta_key", "meta_value", "status"},
+			[]string{"parent", "parent_id", "meta_key"},
+			[]string{"meta_value", "status"},
+		),
+		parent, parentID, key, value, StatusActive,
+	)
+	return err
+}
+
+func (s sqlMetaStore) FindParentID(ctx context.Context, parent, key string, contains ...string) (int64, error) {
+	query := "SELECT parent_id FROM metas WHERE parent = ? AND meta_key = ? AND status = 1"
+	args := []interface{}{parent, key}
+	for _, part := range contains {
+		query += " AND meta_value LIKE ? ESCAPE '!'"
+		args = append(args, "%"+escapeLike(part)+"%")
+	}
+
+	var parentID int64
+	err := s.q().QueryRowContext(ctx, query+" LIMIT 1", args...).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return parentID, err
+}
+
+// likeEscaper escapes the LIKE wildcards with the portable escape character
+// "!", which unlike a backslash is written the same way in every dialect
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// escapeLike makes s match itself literally in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+type sqlUserStore struct {
+	sqlStore
+}
+
+func (s sqlUserStore) findUser(ctx context.Context, where string, arg interface{}) (*User, error) {
+	var u User
+	err := s.q().QueryRowContext(ctx,
+		"SELECT id, system_id, email, password, access_key FROM users WHERE "+where,
+		arg,
+	).Scan(&u.ID, &u.SystemID, &u.Email, &u.Password, &u.AccessKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s sqlUserStore) FindUser(ctx context.Context, id int64) (*User, error) {
+	return s.findUser(ctx, "id = ?", id)
+}
+
+func (s sqlUserStore) FindUserByAccessKey(ctx context.Context, accessKey string) (*User, error) {
+	return s.findUser(ctx, "access_key = ?", accessKey)
+}
+
+func (s sqlUserStore) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.findUser(ctx, s.db.Dialect.EqualFold("email"), email)
+}
+
+func (s sqlUserStore) AddUser(ctx context.Context, u User) (int64, error) {
+	return s.q().InsertIDContext(ctx,
+		"INSERT INTO users (email, password, access_key, system_id) VALUES (?, ?, ?, ?)",
+		u.Email, u.Password, u.AccessKey, u.SystemID,
+	)
+}
+
+type sqlSystemStore struct {
+	sqlStore
+}
+
+func (s sqlSystemStore) FindSystem(ctx context.Context, domain string) (int64, error) {
+	var systemID int64
+	err := s.q().QueryRowContext(ctx,
+		"SELECT id FROM systems WHERE ("+s.db.Dialect.EqualFold("subdomain")+" OR "+s.db.Dialect.EqualFold("domain")+") AND status = 1",
+		domain, domain,
+	).Scan(&systemID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return systemID, err
+}
+
+func (s sqlSystemStore) AddSystem(ctx context.Context, domain string) (int64, error) {
+	return s.q().InsertIDContext(ctx,
+		"INSERT INTO systems (subdomain, domain, status) VALUES (?, ?, 1)",
+		domain, domain,
+	)
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFindParentIDMatchesWildcardsLiterally(t *testing.T) {
+	ctx := context.Background()
+	stores := NewSQLStores(openTestDB(t))
+	if err := stores.Metas.SetMeta(ctx, "user", 7, "validation_key", `{"code":"abc123","timestamp":"x"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{`"code":"%"`, `"code":"______"`, `"code":"abc%"`} {
+		id, err := stores.Metas.FindParentID(ctx, "user", "validation_key", part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != 0 {
+			t.Errorf("FindParentID(%q) = %d, want no match", part, id)
+		}
+	}
+
+	id, err := stores.Metas.FindParentID(ctx, "user", "validation_key", `"code":"abc123"`)
+	if err != nil || id != 7 {
+		t.Errorf("FindParentID(exact code) = %d, %v, want 7", id, err)
+	}
+}
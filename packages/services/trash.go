@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// Block and meta status values. A trashed block keeps status 0; everything
+// soft-deleted along with it gets StatusCascaded so restoring the block brings
+// back exactly what was deleted together with it.
+const (
+	StatusCascaded = -1
+	StatusTrashed  = 0
+	StatusActive   = 1
+)
+
+// ErrParentTrashed is returned when restoring a block whose parent is not active
+var ErrParentTrashed = errors.New("parent block is in the trash")
+
+// ErrNotTrashed is returned when restoring or purging a block that is not in
+// the trash
+var ErrNotTrashed = errors.New("block is not in the trash")
+
+// trashColumns selects a trashed block with the time it was trashed
+const trashColumns = blockColumns + ", deleted_at"
+
+// queryer is the subset of *database.DB and *database.Tx used by helpers that
+// run both inside and outside a transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*database.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *database.Row
+	InsertIDContext(ctx context.Context, query string, args ...interface{}) (int64, error)
+}
+
+type blockRef struct {
+	ID     int64
+	Type   string
+	Status int
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return "?" + strings.Repeat(",?", n-1)
+}
+
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// setBlockStatus moves a block and its metas between statuses. Blocks leaving
+// the active status are stamped with when they did, and restored blocks lose
+// the stamp. Privilege metas are left alone so owners keep access to trashed
+// blocks.
+func setBlockStatus(ctx context.Context, q queryer, ref blockRef, from, to, metaFrom, metaTo int, now string) error {
+	var deletedAt interface{}
+	if to != StatusActive {
+		deletedAt = now
+	}
+	if _, err := q.ExecContext(ctx,
+		"UPDATE blocks SET status = ?, modified_at = ?, deleted_at = ? WHERE id = ? AND status = ?",
+		to, now, deletedAt, ref.ID, from,
+	); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx,
+		"UPDATE metas SET status = ? WHERE parent = ? AND parent_id = ? AND status = ? AND SUBSTR(meta_key, 1, 10) <> 'privilege_'",
+		metaTo, ref.Type, ref.ID, metaFrom,
+	)
+	return err
+}
+
+// trashBlock soft-deletes a block and cascades to its active descendants and
+// their metas
+func trashBlock(ctx context.Context, tx queryer, id int64) error {
+	var ref blockRef
+	err := tx.QueryRowContext(ctx, "SELECT id, type, status FROM blocks WHERE id = ?", id).Scan(&ref.ID, &ref.Type, &ref.Status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if ref.Status != StatusActive {
+		return nil
+	}
+
+	descendants, err := collectDescendants(ctx, tx, id, []int{StatusActive})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if err := setBlockStatus(ctx, tx, ref, StatusActive, StatusTrashed, StatusActive, StatusCascaded, now); err != nil {
+		return err
+	}
+	for _, d := range descendants {
+		if err := setBlockStatus(ctx, tx, d, StatusActive, StatusCascaded, StatusActive, StatusCascaded, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindTrashedBlock fetches a block in the trash by slug
+func (dm *DatabaseManager) FindTrashedBlock(ctx context.Context, slug string) (*Block, error) {
+	b, err := scanBlock(dm.q().QueryRowContext(ctx,
+		"SELECT "+blockColumns+" FROM blocks WHERE slug = ? AND status = ?",
+		slug, StatusTrashed,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetTrash lists trashed top-level blocks the user authored or holds a
+// privilege on
+func (dm *DatabaseManager) GetTrash(ctx context.Context, userID int64) ([]map[string]interface{}, error) {
+	rows, err := dm.q().QueryContext(ctx,
+		"SELECT "+trashColumns+" FROM blocks WHERE status = ? AND parent = 0 AND ( author = ? OR id IN ( SELECT parent_id FROM metas WHERE parent = blocks.type AND meta_key = ? ) ) ORDER BY deleted_at DESC",
+		StatusTrashed, userID, "privilege_"+strconv.FormatInt(userID, 10),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []map[string]interface{}{}
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetTrashUnder lists trashed blocks below an active block that can be restored
+// directly, i.e. whose parent is still active
+func (dm *DatabaseManager) GetTrashUnder(ctx context.Context, rootID int64) ([]map[string]interface{}, error) {
+	active, err := collectDescendants(ctx, dm.q(), rootID, []int{StatusActive})
+	if err != nil {
+		return nil, err
+	}
+
+	parents := []int64{rootID}
+	for _, ref := range active {
+		parents = append(parents, ref.ID)
+	}
+
+	items := []map[string]interface{}{}
+	for start := 0; start < len(parents); start += 500 {
+		end := start + 500
+		if end > len(parents) {
+			end = len(parents)
+		}
+		chunk := parents[start:end]
+
+		args := append([]interface{}{StatusTrashed}, int64Args(chunk)...)
+		rows, err := dm.q().QueryContext(ctx,
+			"SELECT "+trashColumns+" FROM blocks WHERE status = ? AND parent IN ("+placeholders(len(chunk))+") ORDER BY deleted_at DESC",
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			item, err := scanTrashItem(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// scanTrashItem reads a row of trashColumns into the map shape of the API
+func scanTrashItem(row interface{ Scan(...interface{}) error }) (map[string]interface{}, error) {
+	var b Block
+	var deletedAt sql.NullString
+	err := row.Scan(&b.ID, &b.Type, &b.Title, &b.Content, &b.Author, &b.Slug, &b.Parent, &b.CreatedAt, &b.ModifiedAt, &b.Position, &b.Version, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	item := BlockToMap(&b)
+	item["deleted_at"] = FormatTimeToISO(deletedAt.String)
+	return item, nil
+}
+
+// RestoreBlock brings a trashed block back together with everything that was
+// deleted along with it
+func (dm *DatabaseManager) RestoreBlock(ctx context.Context, id int64) error {
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		var ref blockRef
+		var parent int64
+		err := tx.QueryRowContext(ctx, "SELECT id, type, status, parent FROM blocks WHERE id = ?", id).Scan(&ref.ID, &ref.Type, &ref.Status, &parent)
+		if err != nil {
+			return err
+		}
+		if ref.Status != StatusTrashed {
+			return ErrNotTrashed
+		}
+
+		if parent > 0 {
+			var parentStatus int
+			if err := tx.QueryRowContext(ctx, "SELECT status FROM blocks WHERE id = ?", parent).Scan(&parentStatus); err != nil {
+				return err
+			}
+			if parentStatus != StatusActive {
+				return ErrParentTrashed
+			}
+		}
+
+		descendants, err := collectDescendants(ctx, tx, id, []int{StatusCascaded})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Format("2006-01-02 15:04:05")
+		if err := setBlockStatus(ctx, tx, ref, StatusTrashed, StatusActive, StatusCascaded, StatusActive, now); err != nil {
+			return err
+		}
+		for _, d := range descendants {
+			if err := setBlockStatus(ctx, tx, d, StatusCascaded, StatusActive, StatusCascaded, StatusActive, now); err != nil {
+				return err
+			}
+		}
+
+		return tx.publishBlock(ctx, EventBlockUpdated, id)
+	})
+}
+
+// PurgeBlock permanently removes a trashed block, its subtree, their metas,
+// links and revision history
+func (dm *DatabaseManager) PurgeBlock(ctx context.Context, id int64) error {
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		var ref blockRef
+		err := tx.QueryRowContext(ctx, "SELECT id, type, status FROM blocks WHERE id = ?", id).Scan(&ref.ID, &ref.Type, &ref.Status)
+		if err != nil {
+			return err
+		}
+		if ref.Status != StatusTrashed {
+			return ErrNotTrashed
+		}
+
+		descendants, err := collectDescendants(ctx, tx, id, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range append([]blockRef{ref}, descendants...) {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM metas WHERE parent = ? AND parent_id = ?", r.Type, r.ID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM block_revisions WHERE block_id = ?", r.ID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM block_links WHERE source_id = ? OR target_id = ?", r.ID, r.ID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM blocks WHERE id = ?", r.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// PurgeTrash permanently removes every block that has been in the trash for
+// longer than retention and returns how many trashed blocks were purged
+func (dm *DatabaseManager) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	cutoff := time.Now().Add(-retention).Format("2006-01-02 15:04:05")
+	rows, err := dm.q().QueryContext(ctx, "SELECT id FROM blocks WHERE status = ? AND deleted_at < ?", StatusTrashed, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		if err := dm.PurgeBlock(ctx, id); err != nil {
+			// An ancestor purged earlier in this run may have removed it
+			// already, or it was restored since the list was read
+			if err == sql.ErrNoRows || err == ErrNotTrashed {
+				continue
+			}
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartTrashPurger runs PurgeTrash every interval until the returned stop
+// function is called. Stopping also cancels a purge in progress.
+func StartTrashPurger(db *database.DB, retention, interval time.Duration) func() {
+	ctx, stop := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		dm := &DatabaseManager{db: db, stores: NewSQLStores(db)}
+		for {
+			if purged, err := dm.PurgeTrash(ctx, retention); err != nil {
+				log.Println("trash purge failed:", err)
+			} else if purged > 0 {
+				log.Printf("trash purge removed %d blocks", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// blockStatus returns the status of a block, or -1 once it has been purged
+func blockStatus(t *testing.T, db *database.DB, id int64) int {
+	t.Helper()
+	var status int
+	err := db.QueryRow("SELECT status FROM blocks WHERE id = ?", id).Scan(&status)
+	if err != nil {
+		return -1
+	}
+	return status
+}
+
+func TestPurgeTrashCountsRetentionFromDeletion(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := addWorkspace(t, dm, "Deleted long ago")
+	recent := addWorkspace(t, dm, "Deleted just now")
+	for _, id := range []int64{old, recent} {
+		if err := dm.DeleteBlock(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An edit made while trashed must not restart the retention period, and
+	// an old modification time must not shorten it
+	longAgo := time.Now().Add(-10 * 24 * time.Hour).Format("2006-01-02 15:04:05")
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if _, err := db.Exec("UPDATE blocks SET deleted_at = ?, modified_at = ? WHERE id = ?", longAgo, now, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE blocks SET modified_at = ? WHERE id = ?", longAgo, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	trash, err := dm.GetTrash(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 2 || trash[0]["id"] != recent || trash[0]["deleted_at"] == "" {
+		t.Fatalf("trash = %v, want both blocks, most recently deleted first", trash)
+	}
+
+	purged, err := dm.PurgeTrash(ctx, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d blocks, want 1", purged)
+	}
+	if status := blockStatus(t, db, old); status != -1 {
+		t.Errorf("block deleted long ago has status %d, want it purged", status)
+	}
+	if status := blockStatus(t, db, recent); status != StatusTrashed {
+		t.Errorf("block deleted just now has status %d, want it kept in the trash", status)
+	}
+}
+
+func TestRestoredBlocksAreNotPurged(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Restored")
+	if err := dm.DeleteBlock(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.RestoreBlock(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	var deletedAt *string
+	if err := db.QueryRow("SELECT deleted_at FROM blocks WHERE id = ?", id).Scan(&deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if deletedAt != nil {
+		t.Errorf("restored block keeps deleted_at %q", *deletedAt)
+	}
+
+	// The purger lists blocks before purging them one by one, so a block
+	// restored in between reaches PurgeBlock
+	if err := dm.PurgeBlock(ctx, id); err != ErrNotTrashed {
+		t.Errorf("purging a restored block: %v, want ErrNotTrashed", err)
+	}
+	if status := blockStatus(t, db, id); status != StatusActive {
+		t.Errorf("restored block has status %d, want it active", status)
+	}
+}
+
+func TestTrashPurgerPurgesExpiredBlocks(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := addWorkspace(t, dm, "Expired")
+	if err := dm.AddMeta(ctx, "workspace", id, "greeting_message", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.DeleteBlock(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	longAgo := time.Now().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+	if _, err := db.Exec("UPDATE blocks SET deleted_at = ? WHERE id = ?", longAgo, id); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := StartTrashPurger(db, time.Hour, time.Hour)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for blockStatus(t, db, id) != -1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the purger did not remove the expired block")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
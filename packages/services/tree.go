@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBlockCycle is returned when a move would place a block under itself
+var ErrBlockCycle = errors.New("a block cannot be moved under itself or its descendants")
+
+// descendantsCTE builds a recursive CTE named tree (id, depth) holding the
+// descendants of rootID up to maxDepth levels deep. Only blocks matching the
+// status and type filters are returned and walked into.
+func descendantsCTE(rootID int64, maxDepth int, statuses []int, types []string) (string, []interface{}) {
+	filter := ""
+	var filterArgs []interface{}
+	if len(statuses) > 0 {
+		filter += " AND b.status IN (" + placeholders(len(statuses)) + ")"
+		for _, s := range statuses {
+			filterArgs = append(filterArgs, s)
+		}
+	}
+	if len(types) > 0 {
+		filter += " AND b.type IN (" + placeholders(len(types)) + ")"
+		for _, t := range types {
+			filterArgs = append(filterArgs, t)
+		}
+	}
+
+	query := `WITH RECURSIVE tree (id, depth) AS (
+    SELECT b.id, 1 FROM blocks b WHERE b.parent = ?` + filter + `
+    UNION ALL
+    SELECT b.id, t.depth + 1 FROM blocks b INNER JOIN tree t ON b.parent = t.id
+    WHERE t.depth < ?` + filter + `
+)
+`
+	args := []interface{}{rootID}
+	args = append(args, filterArgs...)
+	args = append(args, maxDepth)
+	args = append(args, filterArgs...)
+	return query, args
+}
+
+// collectDescendants returns all descendants of rootID. When statuses is
+// non-empty only blocks with one of those statuses are returned and walked into.
+func collectDescendants(ctx context.Context, q queryer, rootID int64, statuses []int) ([]blockRef, error) {
+	cte, args := descendantsCTE(rootID, maxBlockDepth, statuses, nil)
+	rows, err := q.QueryContext(ctx, cte+"SELECT b.id, b.type, b.status FROM tree t INNER JOIN blocks b ON b.id = t.id ORDER BY t.depth, b.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []blockRef
+	for rows.Next() {
+		var ref blockRef
+		if err := rows.Scan(&ref.ID, &ref.Type, &ref.Status); err != nil {
+			return nil, err
+		}
+		result = append(result, ref)
+	}
+	return result, rows.Err()
+}
+
+// GetSubtree returns the active descendants of a block as nested "children"
+// lists in position order, at most depth levels deep. When types is non-empty
+// only blocks of those types are returned and walked into.
+func (dm *DatabaseManager) GetSubtree(ctx context.Context, rootID int64, depth int, types []string) ([]map[string]interface{}, error) {
+	if depth <= 0 || depth > maxBlockDepth {
+		depth = maxBlockDepth
+	}
+
+	cte, args := descendantsCTE(rootID, depth, []int{StatusActive}, types)
+	rows, err := dm.q().QueryContext(ctx,
+		cte+"SELECT "+qualifiedBlockColumns+" FROM tree t INNER JOIN blocks b ON b.id = t.id ORDER BY t.depth, b.position, b.id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Rows arrive level by level, so every parent is seen before its children
+	nodes := make(map[int64]map[string]interface{})
+	roots := []map[string]interface{}{}
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		node := BlockToMap(b)
+		node["children"] = []map[string]interface{}{}
+		nodes[b.ID] = node
+
+		if b.Parent != nil && *b.Parent == rootID {
+			roots = append(roots, node)
+		} else if b.Parent != nil {
+			if parent, ok := nodes[*b.Parent]; ok {
+				parent["children"] = append(parent["children"].([]map[string]interface{}), node)
+			}
+		}
+	}
+	return roots, rows.Err()
+}
+
+// CountDescendants counts the active descendants of a block, optionally only
+// those of the given types. Blocks of other types are still walked through.
+func (dm *DatabaseManager) CountDescendants(ctx context.Context, rootID int64, types []string) (int, error) {
+	cte, args := descendantsCTE(rootID, maxBlockDepth, []int{StatusActive}, nil)
+	query := cte + "SELECT COUNT(*) FROM tree t INNER JOIN blocks b ON b.id = t.id"
+	if len(types) > 0 {
+		query += " WHERE b.type IN (" + placeholders(len(types)) + ")"
+		for _, t := range types {
+			args = append(args, t)
+		}
+	}
+
+	var count int
+	err := dm.q().QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// ancestorRefs returns the chain of blocks above id, nearest parent first
+func ancestorRefs(ctx context.Context, q queryer, id int64) ([]blockRef, error) {
+	rows, err := q.QueryContext(ctx, `WITH RECURSIVE chain (id, parent, depth) AS (
+    SELECT id, parent, 0 FROM blocks WHERE id = ?
+    UNION ALL
+    SELECT b.id, b.parent, c.depth + 1 FROM blocks b INNER JOIN chain c ON b.id = c.parent
+    WHERE c.parent > 0 AND c.depth < ?
+)
+SELECT b.id, b.type, b.status FROM chain c INNER JOIN blocks b ON b.id = c.id WHERE c.depth > 0 ORDER BY c.depth`,
+		id, maxBlockDepth,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []blockRef
+	for rows.Next() {
+		var ref blockRef
+		if err := rows.Scan(&ref.ID, &ref.Type, &ref.Status); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// GetAncestors returns the blocks above a block, top-level block first, for
+// use as breadcrumbs
+func (dm *DatabaseManager) GetAncestors(ctx context.Context, id int64) ([]map[string]interface{}, error) {
+	refs, err := ancestorRefs(ctx, dm.q(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors := make([]map[string]interface{}, 0, len(refs))
+	for i := len(refs) - 1; i >= 0; i-- {
+		b, err := dm.FindBlock(ctx, "", refs[i].ID, "")
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		ancestors = append(ancestors, BlockToMap(b))
+	}
+	return ancestors, nil
+}
+
+// MoveBlock re-parents a block after checking the new parent accepts its type
+// and that the move would not create a cycle
+func (dm *DatabaseManager) MoveBlock(ctx context.Context, id int64, newParent int64) error {
+	if newParent == id {
+		return ErrBlockCycle
+	}
+
+	// The checks, the cycle test and the new position must see the tree the
+	// update writes
+	return dm.WithTx(ctx, func(tx *Tx) error {
+		block, err := tx.FindBlock(ctx, "", id, "")
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return sql.ErrNoRows
+		}
+
+		def, ok := GetBlockTypeDefinition(block.Type)
+		if !ok {
+			return NewValidationError("type", fmt.Sprintf("unknown block type %q", block.Type))
+		}
+		if def.IsTopLevel() {
+			return NewValidationError("parent", fmt.Sprintf("%s must be top-level", block.Type))
+		}
+
+		parent, err := tx.FindBlock(ctx, "", newParent, "")
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return NewValidationError("parent", "not found")
+		}
+		if !def.AllowsParent(parent.Type) {
+			return NewValidationError("parent", fmt.Sprintf("%s cannot be placed under %s", block.Type, parent.Type))
+		}
+
+		ancestors, err := ancestorRefs(ctx, tx, newParent)
+		if err != nil {
+			return err
+		}
+		for _, a := range ancestors {
+			if a.ID == id {
+				return ErrBlockCycle
+			}
+		}
+
+		position, err := tx.nextPosition(ctx, newParent, block.Type)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE blocks SET parent = ?, position = ?, modified_at = ? WHERE id = ?",
+			newParent, position, time.Now().Format("2006-01-02 15:04:05"), id,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.publishBlock(ctx, EventBlockUpdated, id)
+	})
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMoveBlockRefusesCycles(t *testing.T) {
+	ctx := context.Background()
+	registerTestType(t, BlockTypeDefinition{Name: "folder", Parents: []string{"workspace", "folder"}})
+	db := openTestDB(t)
+	dm, err := NewDatabaseManager(ctx, db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// workspace > top > middle > bottom
+	parent := addWorkspace(t, dm, "Files")
+	folders := map[string]int64{}
+	for _, title := range []string{"top", "middle", "bottom"} {
+		block, err := dm.AddBlock(ctx, 1, map[string]interface{}{"type": "folder", "title": title, "content": "", "parent": parent}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		folders[title] = block["id"].(int64)
+		parent = folders[title]
+	}
+	parentOf := func(id int64) int64 {
+		t.Helper()
+		block, err := dm.FindBlock(ctx, "", id, "")
+		if err != nil || block == nil || block.Parent == nil {
+			t.Fatalf("block %d: %v, %v", id, block, err)
+		}
+		return *block.Parent
+	}
+
+	for _, into := range []string{"top", "middle", "bottom"} {
+		if err := dm.MoveBlock(ctx, folders["top"], folders[into]); err != ErrBlockCycle {
+			t.Errorf("moving top into %s: got %v, want ErrBlockCycle", into, err)
+		}
+	}
+	if got := parentOf(folders["middle"]); got != folders["top"] {
+		t.Errorf("a refused move changed the tree: middle is under %d", got)
+	}
+
+	// Moving up the tree is fine
+	if err := dm.MoveBlock(ctx, folders["bottom"], folders["top"]); err != nil {
+		t.Fatal(err)
+	}
+	if got := parentOf(folders["bottom"]); got != folders["top"] {
+		t.Errorf("bottom is under %d, want %d", got, folders["top"])
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/miumoin/agencybot/packages/database"
+)
+
+// Tx is a DatabaseManager whose queries and store reads and writes run in
+// one database transaction. It is only valid inside the function passed to
+// WithTx.
+type Tx struct {
+	*DatabaseManager
+}
+
+// WithTx runs fn in a transaction that commits when fn returns nil and rolls
+// back otherwise. Called on a Tx, fn joins the open transaction. Events
+// published in fn are delivered once the transaction commits.
+func (dm *DatabaseManager) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	if dm.tx != nil {
+		return fn(&Tx{dm})
+	}
+
+	sqlTx, err := dm.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	var events []Event
+	txm := *dm
+	txm.tx = sqlTx
+	txm.stores = dm.stores.withTx(sqlTx)
+	txm.events = &events
+	if err := fn(&Tx{&txm}); err != nil {
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		dm.publish(ctx, e)
+	}
+	return nil
+}
+
+// q returns the transaction the manager runs in, or else its database
+func (dm *DatabaseManager) q() queryer {
+	if dm.tx != nil {
+		return dm.tx
+	}
+	return dm.db
+}
+
+// ExecContext runs a statement in the transaction
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.tx.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query in the transaction
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*database.Rows, error) {
+	return tx.tx.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a single-row query in the transaction
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *database.Row {
+	return tx.tx.QueryRowContext(ctx, query, args...)
+}
+
+// InsertIDContext runs an INSERT in the transaction and returns the new
+// row's id
+func (tx *Tx) InsertIDContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return tx.tx.InsertIDContext(ctx, query, args...)
+}
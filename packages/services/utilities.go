@@ -2,7 +2,9 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
@@ -18,6 +20,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/miumoin/agencybot/packages/database"
 )
 
 // Request wrapper for HTTP
@@ -43,18 +46,20 @@ func (r *Request) GetContent() ([]byte, error) {
 
 // Utilities struct (like your PHP class)
 type Utilities struct {
-	db *sql.DB
+	db     *database.DB
+	stores Stores
 }
 
 // Constructor equivalent
-func NewUtilities(db *sql.DB) *Utilities {
+func NewUtilities(db *database.DB, stores Stores) *Utilities {
 	return &Utilities{
-		db: db,
+		db:     db,
+		stores: stores,
 	}
 }
 
 // ---- Core Method ----
-func (u *Utilities) MakeLogin(databaseManager DatabaseManager, c *gin.Context) (int64, string, string, error) {
+func (u *Utilities) MakeLogin(ctx context.Context, databaseManager DatabaseManager, c *gin.Context) (int64, string, string, error) {
 	req := &Request{c.Request}
 
 	// Parse JSON
@@ -74,7 +79,7 @@ func (u *Utilities) MakeLogin(databaseManager DatabaseManager, c *gin.Context) (
 
 	// Add user
 	email := content["email"].(string)
-	userID, err := databaseManager.AddUser(email, GetMD5Hash(password))
+	userID, err := databaseManager.AddUser(ctx, email, GetMD5Hash(password))
 
 	if err != nil && userID == 0 {
 		return 0, "", "", err
@@ -86,7 +91,7 @@ func (u *Utilities) MakeLogin(databaseManager DatabaseManager, c *gin.Context) (
 	// If Google login
 	if _, hasAud := content["aud"]; hasAud {
 		if _, hasAzp := content["azp"]; hasAzp {
-			emailAndKey, err := databaseManager.GetAccessKey(userID)
+			emailAndKey, err := databaseManager.GetAccessKey(ctx, userID)
 			if err != nil {
 				return 0, "", "", err
 			}
@@ -105,7 +110,7 @@ func (u *Utilities) MakeLogin(databaseManager DatabaseManager, c *gin.Context) (
 		"timestamp": time.Now().Format(time.RFC3339),
 		"code":      password,
 	}
-	if err := databaseManager.AddMeta("user", userID, "validation_key", meta); err != nil {
+	if err := databaseManager.AddMeta(ctx, "user", userID, "validation_key", meta); err != nil {
 		return 0, "", "", err
 	}
 
@@ -130,11 +135,47 @@ The Typewriting Team`, password)
 <p>Thanks,<br>The Typewriting Team</p>`, password)
 
 	// Placeholder for email sending
-	u.SendEmail(userEmail, subject, messagePlain, messageHTML)
+	u.SendEmail(ctx, userEmail, subject, messagePlain, messageHTML)
 
 	return userID, userEmail, accessKey, nil
 }
 
+// LoginCodeTTL is how long a verification code sent by MakeLogin is accepted
+const LoginCodeTTL = 30 * time.Minute
+
+// VerifyLoginCode returns the user a verification code was sent to, or 0 when
+// no user has that exact code or it has expired
+func (u *Utilities) VerifyLoginCode(ctx context.Context, code string) (int64, error) {
+	if code == "" {
+		return 0, nil
+	}
+	// The lookup narrows the candidates; the code is compared exactly below
+	userID, err := u.stores.Metas.FindParentID(ctx, "user", "validation_key", `"code":"`+code+`"`)
+	if err != nil || userID == 0 {
+		return 0, err
+	}
+	value, ok, err := u.stores.Metas.GetMeta(ctx, "user", userID, "validation_key")
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	var key struct {
+		Code      string `json:"code"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal([]byte(value), &key); err != nil {
+		return 0, nil
+	}
+	sentAt, err := time.Parse(time.RFC3339, key.Timestamp)
+	if err != nil || time.Since(sentAt) > LoginCodeTTL {
+		return 0, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Code), []byte(code)) != 1 {
+		return 0, nil
+	}
+	return userID, nil
+}
+
 func GetMD5Hash(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
@@ -147,7 +188,7 @@ func (u *Utilities) uniqid() string {
 }
 
 // AddNewProfile inserts a new thread block
-func (u *Utilities) AddNewProfile(workspaceID, userID int, r *http.Request) (bool, error) {
+func (u *Utilities) AddNewProfile(ctx context.Context, workspaceID, userID int, r *http.Request) (bool, error) {
 	var content map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
 		return false, err
@@ -160,12 +201,12 @@ func (u *Utilities) AddNewProfile(workspaceID, userID int, r *http.Request) (boo
 
 	slug := u.uniqid()
 	existingID := 0
-	err := u.db.QueryRow("SELECT id FROM blocks WHERE slug = ?", slug).Scan(&existingID)
+	err := u.db.QueryRowContext(ctx, "SELECT id FROM blocks WHERE slug = ?", slug).Scan(&existingID)
 	if err != sql.ErrNoRows && err != nil {
 		return false, err
 	}
 
-	_, err = u.db.Exec("INSERT INTO blocks (type, title, content, parent, user_id) VALUES (?, ?, ?, ?, ?)",
+	_, err = u.db.ExecContext(ctx, "INSERT INTO blocks (type, title, content, parent, user_id) VALUES (?, ?, ?, ?, ?)",
 		"thread", title, "", workspaceID, userID)
 
 	if err != nil {
@@ -175,7 +216,7 @@ func (u *Utilities) AddNewProfile(workspaceID, userID int, r *http.Request) (boo
 }
 
 // DeleteProfile removes a block by ID
-func (u *Utilities) DeleteProfile(r *http.Request) error {
+func (u *Utilities) DeleteProfile(ctx context.Context, r *http.Request) error {
 	var content map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
 		return err
@@ -186,7 +227,7 @@ func (u *Utilities) DeleteProfile(r *http.Request) error {
 		return sql.ErrNoRows
 	}
 
-	_, err := u.db.Exec("DELETE FROM blocks WHERE id = ?", id)
+	_, err := u.db.ExecContext(ctx, "DELETE FROM blocks WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
@@ -195,10 +236,10 @@ func (u *Utilities) DeleteProfile(r *http.Request) error {
 }
 
 // GetWorkspaceProfile fetches a thread block + its collected info
-func (u *Utilities) GetWorkspaceProfile(workspaceID int, slug string) (*map[string]interface{}, error) {
+func (u *Utilities) GetWorkspaceProfile(ctx context.Context, workspaceID int, slug string) (*map[string]interface{}, error) {
 	var profile Block
 
-	err := u.db.QueryRow(
+	err := u.db.QueryRowContext(ctx,
 		"SELECT b.id, b.title, b.slug FROM blocks b WHERE b.slug = ? AND b.type = ? AND b.parent = ? LIMIT 1",
 		slug, "thread", workspaceID,
 	).Scan(&profile.ID, &profile.Title, &profile.Slug)
@@ -212,7 +253,7 @@ func (u *Utilities) GetWorkspaceProfile(workspaceID int, slug string) (*map[stri
 
 	// fetch collected_information
 	var collectedInfo sql.NullString
-	err = u.db.QueryRow(
+	err = u.db.QueryRowContext(ctx,
 		"SELECT meta_value FROM metas WHERE parent_id = ? AND parent = ? AND meta_key = ? LIMIT 1",
 		profile.ID, "thread", "collected_information",
 	).Scan(&collectedInfo)
@@ -242,7 +283,7 @@ func (u *Utilities) GetWorkspaceProfile(workspaceID int, slug string) (*map[stri
 // ------------------------------------------------------------------
 // GetProfile
 // ------------------------------------------------------------------
-func (u *Utilities) GetProfile(slug string) (map[string]interface{}, error) {
+func (u *Utilities) GetProfile(ctx context.Context, slug string) (map[string]interface{}, error) {
 	var (
 		id      int
 		parent  int
@@ -251,7 +292,7 @@ func (u *Utilities) GetProfile(slug string) (map[string]interface{}, error) {
 		slugOut string
 	)
 
-	err := u.db.QueryRow(
+	err := u.db.QueryRowContext(ctx,
 		"SELECT b.id, b.title, b.slug, b.parent, b.author FROM blocks b WHERE b.slug = ? AND b.type = ? AND b.status = ? LIMIT 1",
 		slug, "thread", 1,
 	).Scan(&id, &title, &slugOut, &parent, &author)
@@ -338,6 +379,12 @@ func (u *Utilities) CleanText(text string) string {
 // Cosine similarity helper
 // -----------------------------
 func (u *Utilities) CosineSimilarity(vecA, vecB []float64) float64 {
+	return CosineSimilarity(vecA, vecB)
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors, or 0
+// when their lengths differ or either is zero
+func CosineSimilarity(vecA, vecB []float64) float64 {
 	if len(vecA) != len(vecB) {
 		return 0
 	}
@@ -384,7 +431,7 @@ func (u *Utilities) truncateString(str string, max int) string {
 // -----------------------------
 // Send email via Mailjet
 // -----------------------------
-func (u *Utilities) SendEmail(recipient, subject, messagePlain, messageHTML string) error {
+func (u *Utilities) SendEmail(ctx context.Context, recipient, subject, messagePlain, messageHTML string) error {
 	apiKey := os.Getenv("MAILJET_API_KEY")
 	apiSecret := os.Getenv("MAILJET_API_SECRET")
 	senderEmail := os.Getenv("MAILJET_SENDER_EMAIL")
@@ -408,7 +455,7 @@ func (u *Utilities) SendEmail(recipient, subject, messagePlain, messageHTML stri
 	}
 
 	jsonBody, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", "https://api.mailjet.com/v3.1/send", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.mailjet.com/v3.1/send", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return err
 	}
@@ -432,13 +479,12 @@ func (u *Utilities) SendEmail(recipient, subject, messagePlain, messageHTML stri
 // -----------------------------
 // Get subscription info for a user
 // -----------------------------
-func (u *Utilities) GetSubscriptionInfo(db *sql.DB, userID int64) (map[string]interface{}, error) {
+func (u *Utilities) GetSubscriptionInfo(ctx context.Context, userID int64) (map[string]interface{}, error) {
 	subscription := make(map[string]interface{})
 	subscription["user_id"] = userID
 
-	var subscriptionJSON string
-	err := db.QueryRow("SELECT meta_value FROM metas WHERE parent='user' AND parent_id=? AND meta_key='subscription'", userID).Scan(&subscriptionJSON)
-	if err != nil && err != sql.ErrNoRows {
+	subscriptionJSON, _, err := u.stores.Metas.GetMeta(ctx, "user", userID, "subscription")
+	if err != nil {
 		return nil, err
 	}
 
@@ -449,16 +495,7 @@ func (u *Utilities) GetSubscriptionInfo(db *sql.DB, userID int64) (map[string]in
 	}
 
 	// Count threads for the user
-	var threadsCount int
-	query := `
-		SELECT COUNT(t.id)
-		FROM blocks t
-		INNER JOIN blocks w ON t.parent = w.id
-		WHERE t.type='thread' AND t.status=1
-		AND w.type='workspace' AND w.status=1
-		AND w.author=?
-	`
-	err = db.QueryRow(query, userID).Scan(&threadsCount)
+	threadsCount, err := u.stores.Blocks.CountChildren(ctx, "workspace", "thread", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -470,22 +507,7 @@ func (u *Utilities) GetSubscriptionInfo(db *sql.DB, userID int64) (map[string]in
 // -----------------------------
 // Get subscriber user ID by Stripe customer and subscription ID
 // -----------------------------
-func (u *Utilities) GetSubscriberUserID(db *sql.DB, customerID, subscriptionID string) (int, error) {
-	var parentID int
-	query := `
-		SELECT parent_id
-		FROM metas
-		WHERE parent='user' AND meta_key='subscription'
-		AND meta_value LIKE ? AND meta_value LIKE ?
-		LIMIT 1
-	`
-	err := db.QueryRow(query, "%"+customerID+"%", "%"+subscriptionID+"%").Scan(&parentID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, nil // Not found
-		}
-		return 0, err
-	}
-
-	return parentID, nil
+func (u *Utilities) GetSubscriberUserID(ctx context.Context, customerID, subscriptionID string) (int, error) {
+	parentID, err := u.stores.Metas.FindParentID(ctx, "user", "subscription", customerID, subscriptionID)
+	return int(parentID), err
 }
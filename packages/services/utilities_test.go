@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestVerifyLoginCode(t *testing.T) {
+	ctx := context.Background()
+	stores := NewSQLStores(openTestDB(t))
+	u := NewUtilities(nil, stores)
+
+	setCode := func(userID int64, code string, sentAt time.Time) {
+		value, _ := json.Marshal(map[string]string{"code": code, "timestamp": sentAt.Format(time.RFC3339)})
+		if err := stores.Metas.SetMeta(ctx, "user", userID, "validation_key", string(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setCode(1, "fresh1", time.Now())
+	setCode(2, "stale2", time.Now().Add(-LoginCodeTTL-time.Minute))
+
+	tests := []struct {
+		code string
+		want int64
+	}{
+		{"fresh1", 1},
+		{"stale2", 0},
+		{"fresh", 0},
+		{"%", 0},
+		{"______", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		got, err := u.VerifyLoginCode(ctx, tt.code)
+		if err != nil {
+			t.Fatalf("VerifyLoginCode(%q): %v", tt.code, err)
+		}
+		if got != tt.want {
+			t.Errorf("VerifyLoginCode(%q) = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
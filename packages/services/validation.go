@@ -0,0 +1,48 @@
+package services
+
+import (
+	"sort"
+	"strings"
+)
+
+// ValidationError carries per-field validation messages
+type ValidationError struct {
+	Fields map[string]string
+}
+
+// NewValidationError builds a ValidationError for a single field
+func NewValidationError(field, message string) *ValidationError {
+	return &ValidationError{Fields: map[string]string{field: message}}
+}
+
+// Add records a message for a field, keeping the first one reported
+func (e *ValidationError) Add(field, message string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	if _, exists := e.Fields[field]; !exists {
+		e.Fields[field] = message
+	}
+}
+
+// OrNil returns nil when no field failed so the result can be used as an error
+func (e *ValidationError) OrNil() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+e.Fields[k])
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}